@@ -7,11 +7,14 @@ require (
 	github.com/gorilla/websocket v1.4.2
 	github.com/henryleu/go-vad v0.0.0-20200925103050-5d9401650b7d
 	github.com/henryleu/go-wav v0.0.0-20200916035820-670a6e7b535b
-	github.com/henryleu/go-webrtcvad v0.0.0-20200925082944-0ae41bfc2df6 // indirect
+	github.com/henryleu/go-webrtcvad v0.0.0-20200925082944-0ae41bfc2df6
 	github.com/jmespath/go-jmespath v0.3.0 // indirect
 	github.com/json-iterator/go v1.1.10 // indirect
 	github.com/kirinlabs/HttpRequest v1.0.5
 	github.com/modern-go/reflect2 v1.0.1 // indirect
 	github.com/muesli/cache2go v0.0.0-20200423001931-a100c5aac93f
+	github.com/prometheus/client_golang v1.7.0
+	github.com/sirupsen/logrus v1.7.0
 	gopkg.in/ini.v1 v1.61.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,141 @@
+package hly
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/henryleu/vads/hly/config"
+	"github.com/henryleu/vads/hly/logging"
+)
+
+// writeJSON writes the value as the json body of the admin response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logging.Phase(logging.PhaseAdmin).Errorf("fail to write admin response, error = %v", err)
+	}
+}
+
+// errorBody is the json body of the failed admin response
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// AdminHandler returns the handler of the admin endpoints, which should be served on
+// server.admin_listen rather than the public listener:
+//
+//	POST   /admin/reload          reloads the config from the yaml file
+//	GET    /admin/readyz          reports the readiness checks with the errors of the failed ones
+//	GET    /admin/sessions        lists the running sessions
+//	GET    /admin/sessions/{cid}  gets the running sessions with the cid
+//	DELETE /admin/sessions/{cid}  terminates the running sessions with the cid
+//
+// The requests should carry the header "Authorization: Bearer <token>" if server.admin_token
+// or server.admin_token_file is set, and the token in use is read on every request, so that
+// the rotated one takes effect without restart.
+func (srv *Server) AdminHandler(configPath string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/reload", ReloadHandler(configPath))
+	mux.HandleFunc("/admin/readyz", srv.readyzHandler(true))
+	sessions := http.StripPrefix("/admin/sessions", srv.SessionsHandler())
+	mux.Handle("/admin/sessions", sessions)
+	mux.Handle("/admin/sessions/", sessions)
+	return requireToken(mux)
+}
+
+// requireToken checks the bearer token of the admin requests if the admin token is set
+func requireToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &config.Current().Server
+		if s.AdminAuth() {
+			token, err := config.Credential("server.admin_token", s.AdminToken, s.AdminTokenFile)
+			if err != nil {
+				logging.Phase(logging.PhaseAdmin).Errorf("fail to read admin token, error = %v", err)
+				writeJSON(w, http.StatusServiceUnavailable, &errorBody{Error: "admin token unavailable"})
+				return
+			}
+			auth := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+				logging.Phase(logging.PhaseAdmin).Warnf("unauthorized admin request %v %v from %v", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSON(w, http.StatusUnauthorized, &errorBody{Error: "unauthorized"})
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ReloadHandler returns the admin handler which reloads the config from the yaml file,
+// e.g. POST /admin/reload. The new config takes effect on the new sessions only.
+func ReloadHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, &errorBody{Error: "method not allowed"})
+			return
+		}
+		changes, err := config.Reload(path)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, &errorBody{Error: err.Error()})
+			return
+		}
+		if changes == nil {
+			changes = []string{}
+		}
+		writeJSON(w, http.StatusOK, map[string][]string{"changes": changes})
+	}
+}
+
+// SessionsHandler returns the admin handler of the session registry, which should be mounted
+// with the prefix stripped, e.g. http.StripPrefix("/admin/sessions", srv.SessionsHandler())
+//
+//	GET    /admin/sessions        lists the running sessions
+//	GET    /admin/sessions/{cid}  gets the running sessions with the cid
+//	DELETE /admin/sessions/{cid}  terminates the running sessions with the cid, and responds 409
+//	                              if they are closing and none of them can be interrupted
+func (srv *Server) SessionsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cid := strings.Trim(r.URL.Path, "/")
+		if cid == "" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				writeJSON(w, http.StatusMethodNotAllowed, &errorBody{Error: "method not allowed"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string][]*SessionInfo{"sessions": srv.Sessions()})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			infos := srv.Session(cid)
+			if len(infos) == 0 {
+				writeJSON(w, http.StatusNotFound, &errorBody{Error: fmt.Sprintf("session %v not found", cid)})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string][]*SessionInfo{"sessions": infos})
+		case http.MethodDelete:
+			n, closing := srv.Terminate(cid, TerminatedReason)
+			if n == 0 && closing == 0 {
+				writeJSON(w, http.StatusNotFound, &errorBody{Error: fmt.Sprintf("session %v not found", cid)})
+				return
+			}
+			logging.Phase(logging.PhaseAdmin).WithField(logging.FieldCID, cid).Infof("%v sessions are terminated by admin from %v, %v sessions are closing", n, r.RemoteAddr, closing)
+			status := http.StatusOK
+			if n == 0 {
+				// the sessions are recognizing the last utterance or closing, and can't be interrupted
+				status = http.StatusConflict
+			}
+			writeJSON(w, status, map[string]int{"terminated": n, "closing": closing})
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeJSON(w, http.StatusMethodNotAllowed, &errorBody{Error: "method not allowed"})
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package asr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/henryleu/vads/hly/config"
+)
+
+/**
+*	深思维语音识别调用方法如下：
+*	url: ip:port/aicyber/asr/gpu/stream/gpu/recognise/
+*	headers = {
+*		'Content-Type': 'audio/wav;rate=%s' % framerate,
+*		'Content-length': str(len(voice_data))
+*	}
+*   返回结果：
+*	{
+*	  "status": "ok",
+*      "data": [{
+*	    	"text": "你好我是私人助理小贼请问您打电话过来有什么事情吗。"
+*	  }]
+*    }
+*
+* */
+
+// aicyber recognizes the audio by the aicyber service
+type aicyber struct {
+	c config.Aicyber
+}
+
+func newAicyber(c *config.ASR) (Recognizer, error) {
+	if c.Aicyber.URL == "" {
+		return nil, fmt.Errorf("asr error - asr.aicyber.url is empty")
+	}
+	return &aicyber{c: c.Aicyber}, nil
+}
+
+// Recognize posts the audio to the aicyber service with its format and sample rate in the
+// content type, e.g. audio/wav;rate=16000
+func (r *aicyber) Recognize(ctx context.Context, audio []byte, format Format) (*Result, error) {
+	contentType := fmt.Sprintf("audio/%v;rate=%v", format.Format, format.SampleRate)
+	body, err := post(ctx, r.c.URL, contentType, nil, audio)
+	if err != nil {
+		return nil, err
+	}
+	//定义asr识别数据结构体
+	var res struct {
+		Status string `json:"status"`
+		Data   []struct {
+			Text string `json:"text"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("asr error - illegal response %q, error: %v", body, err)
+	}
+	if res.Status != "ok" {
+		return nil, fmt.Errorf("asr error - recognition failed with status %q", res.Status)
+	}
+	result := &Result{Engine: config.EngineAicyber}
+	if len(res.Data) > 0 {
+		result.Text = res.Data[0].Text
+	}
+	return result, nil
+}
@@ -0,0 +1,59 @@
+package asr
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/henryleu/vads/hly/config"
+)
+
+func TestAicyberRecognize(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      Format
+		response    string
+		contentType string
+		text        string
+		ok          bool
+	}{
+		{"8k", Format{FormatWAV, 8000}, `{"status":"ok","data":[{"text":"你好"}]}`, "audio/wav;rate=8000", "你好", true},
+		{"16k", Format{FormatWAV, 16000}, `{"status":"ok","data":[{"text":"你好"}]}`, "audio/wav;rate=16000", "你好", true},
+		{"no speech", Format{FormatWAV, 16000}, `{"status":"ok","data":[]}`, "audio/wav;rate=16000", "", true},
+		{"failed", Format{FormatWAV, 16000}, `{"status":"error"}`, "audio/wav;rate=16000", "", false},
+	}
+	audio := []byte("RIFF....WAVEfmt ")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contentType string
+			var body []byte
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType = r.Header.Get("Content-Type")
+				body, _ = ioutil.ReadAll(r.Body)
+				w.Write([]byte(tt.response))
+			}))
+			defer s.Close()
+
+			r, err := New(config.EngineAicyber, &config.ASR{Aicyber: config.Aicyber{URL: s.URL}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := r.Recognize(context.Background(), audio, tt.format)
+			if contentType != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, tt.contentType)
+			}
+			if !bytes.Equal(body, audio) {
+				t.Errorf("body = %q, want %q", body, audio)
+			}
+			if (err == nil) != tt.ok {
+				t.Fatalf("Recognize() error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && res.Text != tt.text {
+				t.Errorf("Recognize() text = %q, want %q", res.Text, tt.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package asr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
+	"github.com/henryleu/vads/hly/config"
+)
+
+/**
+ * 设置HTTP REST POST请求
+ * 1.使用http协议
+ * 2.语音识别服务域名：nls-gateway.cn-shanghai.aliyuncs.com
+ * 3.语音识别接口请求路径：/stream/v1/asr
+ * 4.设置必须请求参数：appkey、format、sample_rate，
+ * 5.设置可选请求参数：enable_punctuation_prediction、enable_inverse_text_normalization、enable_voice_detection
+ * 6.设置HTTP 头部字段：X-NLS-Token 鉴权参数；Content-Type：application/octet-stream
+ */
+
+// aliyun recognizes the audio by the aliyun service
+type aliyun struct {
+	c config.Aliyun
+}
+
+func newAliyun(c *config.ASR) (Recognizer, error) {
+	return &aliyun{c: c.Aliyun}, nil
+}
+
+// Recognize posts the audio to the aliyun service with the token of the access key.
+// The credentials are read on every recognition, so that the rotated ones take effect at once,
+// and the token is cached until it is about to expire or the credentials are changed.
+func (r *aliyun) Recognize(ctx context.Context, audio []byte, format Format) (*Result, error) {
+	cred, err := r.c.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	token, err := r.token(ctx, cred)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("appkey", cred.AppKey)
+	q.Set("format", format.Format)
+	q.Set("sample_rate", strconv.Itoa(format.SampleRate))
+	headers := map[string]string{"X-NLS-Token": token}
+	body, err := post(ctx, r.c.URL+"?"+q.Encode(), "application/octet-stream", headers, audio)
+	if err != nil {
+		return nil, err
+	}
+	//定义asr识别数据结构体
+	var res struct {
+		TaskID  string `json:"task_id"`
+		Result  string `json:"result"`
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("asr error - illegal response %q, error: %v", body, err)
+	}
+	if res.Message != "SUCCESS" {
+		return nil, fmt.Errorf("asr error - recognition %v failed with status %v, message %q", res.TaskID, res.Status, res.Message)
+	}
+	return &Result{Engine: config.EngineAliyun, Text: res.Result, TaskID: res.TaskID}, nil
+}
+
+// tokenMargin is the time before the expiry of a token when a new one is created
+const tokenMargin = 5 * time.Minute
+
+// aliyunToken is a token created by the access key, which is valid until expiry
+type aliyunToken struct {
+	id     string
+	expiry time.Time
+}
+
+// tokenKey identifies the cached token, so that a token is created again once the region,
+// the domain or the access key is changed
+type tokenKey struct {
+	region, domain, id, secret string
+}
+
+var (
+	tokenMutex sync.Mutex
+	tokens     = make(map[tokenKey]*aliyunToken)
+)
+
+// token returns the cached token of the access key, or creates a new one if it is missing
+// or about to expire
+func (r *aliyun) token(ctx context.Context, cred *config.AliyunCredentials) (string, error) {
+	key := tokenKey{region: r.c.Region, domain: r.c.TokenDomain, id: cred.AccessKeyID, secret: cred.AccessKeySecret}
+	tokenMutex.Lock()
+	t, ok := tokens[key]
+	tokenMutex.Unlock()
+	if ok && time.Now().Add(tokenMargin).Before(t.expiry) {
+		return t.id, nil
+	}
+
+	t, err := r.createToken(ctx, cred)
+	if err != nil {
+		return "", err
+	}
+	tokenMutex.Lock()
+	for k := range tokens {
+		if k.region == key.region && k.domain == key.domain {
+			delete(tokens, k)
+		}
+	}
+	tokens[key] = t
+	tokenMutex.Unlock()
+	return t.id, nil
+}
+
+// createToken creates a token of the recognition by the access key. The request is given up
+// once the context is done, and it times out at the deadline of the context.
+func (r *aliyun) createToken(ctx context.Context, cred *config.AliyunCredentials) (*aliyunToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("asr error - fail to create token, error: %v", err)
+	}
+	client, err := sdk.NewClientWithAccessKey(r.c.Region, cred.AccessKeyID, cred.AccessKeySecret)
+	if err != nil {
+		return nil, fmt.Errorf("asr error - fail to create aliyun client, error: %v", err)
+	}
+	request := requests.NewCommonRequest()
+	request.Method = "POST"
+	request.Domain = r.c.TokenDomain
+	request.ApiName = "CreateToken"
+	request.Version = "2019-02-28"
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		request.SetConnectTimeout(timeout)
+		request.SetReadTimeout(timeout)
+	}
+
+	type result struct {
+		response *responses.CommonResponse
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := client.ProcessCommonRequest(request)
+		done <- result{response, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("asr error - fail to create token, error: %v", ctx.Err())
+	}
+	if res.err != nil {
+		return nil, fmt.Errorf("asr error - fail to create token, error: %v", res.err)
+	}
+
+	var body struct {
+		Token struct {
+			ID         string `json:"Id"`
+			ExpireTime int64  `json:"ExpireTime"`
+		} `json:"Token"`
+	}
+	if err := json.Unmarshal(res.response.GetHttpContentBytes(), &body); err != nil || body.Token.ID == "" {
+		return nil, fmt.Errorf("asr error - illegal token response %q", res.response.GetHttpContentString())
+	}
+	return &aliyunToken{id: body.Token.ID, expiry: time.Unix(body.Token.ExpireTime, 0)}, nil
+}
@@ -0,0 +1,101 @@
+// Package asr defines the Recognizer of the speech recognition services. The recognizers
+// are registered by name, e.g. aliyun, aicyber and http, and the one of a session is selected
+// by asr.engine in the config or the asr of its profile.
+package asr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/henryleu/vads/hly/config"
+)
+
+// the formats of the audio
+const (
+	// FormatWAV is the wave file with the header
+	FormatWAV = "wav"
+
+	// FormatPCM is the 16 bits linear pcm without header
+	FormatPCM = "pcm"
+)
+
+// Format describes the audio to recognize
+type Format struct {
+	// Format is the format of the audio, wav or pcm
+	Format string
+
+	// SampleRate is the sample rate of the audio
+	SampleRate int
+}
+
+// Result is the result of a recognition
+type Result struct {
+	// Engine is the name of the recognizer
+	Engine string `json:"engine"`
+
+	// Text is the text recognized, which is empty if no speech is recognized
+	Text string `json:"text"`
+
+	// TaskID is the id of the recognition in the service if it has one
+	TaskID string `json:"task_id,omitempty"`
+}
+
+// Recognizer recognizes the audio by a speech recognition service
+type Recognizer interface {
+	// Recognize recognizes the audio in the format. It returns an error if the service fails,
+	// or the audio can't be recognized, and returns the result with empty text if no speech
+	// is recognized.
+	Recognize(ctx context.Context, audio []byte, format Format) (*Result, error)
+}
+
+// Factory creates the recognizer with the asr config
+type Factory func(c *config.ASR) (Recognizer, error)
+
+var (
+	mutex     sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register registers the factory of the recognizer by name, so that it can be selected by
+// asr.engine and the asr of profiles. It panics if the name is registered twice.
+// It should be called in init, before the config is loaded.
+func Register(name string, f Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("asr error - recognizer %q is registered twice", name))
+	}
+	factories[name] = f
+	config.RegisterEngine(name)
+}
+
+// New creates the recognizer by name with the asr config
+func New(name string, c *config.ASR) (Recognizer, error) {
+	mutex.RLock()
+	f, ok := factories[name]
+	mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("asr error - recognizer %q is not registered", name)
+	}
+	return f(c)
+}
+
+// Names returns the names of the registered recognizers in order
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(config.EngineAliyun, newAliyun)
+	Register(config.EngineAicyber, newAicyber)
+	Register(config.EngineHTTP, newHTTP)
+}
@@ -0,0 +1,95 @@
+package asr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/henryleu/vads/hly/config"
+)
+
+// httpRecognizer recognizes the audio by the generic http service
+type httpRecognizer struct {
+	c config.HTTP
+}
+
+func newHTTP(c *config.ASR) (Recognizer, error) {
+	if c.HTTP.URL == "" {
+		return nil, fmt.Errorf("asr error - asr.http.url is empty")
+	}
+	return &httpRecognizer{c: c.HTTP}, nil
+}
+
+// Recognize posts the audio with the content type and the headers in the config,
+// and takes the text from the field of the json response
+func (r *httpRecognizer) Recognize(ctx context.Context, audio []byte, format Format) (*Result, error) {
+	contentType := strings.NewReplacer(
+		"{format}", format.Format,
+		"{rate}", strconv.Itoa(format.SampleRate),
+	).Replace(r.c.ContentType)
+	body, err := post(ctx, r.c.URL, contentType, r.c.Headers, audio)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, fmt.Errorf("asr error - illegal response %q, error: %v", body, err)
+	}
+	text, ok := lookup(v, r.c.TextField)
+	if !ok {
+		return nil, fmt.Errorf("asr error - no text field %q in response %q", r.c.TextField, body)
+	}
+	return &Result{Engine: config.EngineHTTP, Text: text}, nil
+}
+
+// lookup returns the string in the path of the json value, whose elements are separated
+// by dots and the array elements are indexed by numbers, e.g. data.0.text
+func lookup(v interface{}, path string) (string, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			v = o[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(o) {
+				return "", false
+			}
+			v = o[i]
+		default:
+			return "", false
+		}
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// post posts the audio to the url and returns the body of the response,
+// or an error if the status is not 2xx
+func post(ctx context.Context, url, contentType string, headers map[string]string, audio []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(audio))
+	if err != nil {
+		return nil, fmt.Errorf("asr error - illegal url %q, error: %v", url, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("asr error - fail to post audio, error: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("asr error - fail to read response, error: %v", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("asr error - unexpected status %v, response %q", res.Status, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,59 @@
+package audio
+
+// FrameAssembler assembles the voice in chunks of any size into frames of a fixed size.
+// The bytes which are not enough for a frame are kept and carried over to the next chunk,
+// so that no sample is lost on the chunk boundaries. It is not safe for concurrent use.
+type FrameAssembler struct {
+	size int
+	buf  []byte
+
+	// off is the offset in buf of the next frame
+	off int
+}
+
+// NewFrameAssembler creates a frame assembler which assembles frames in size bytes
+func NewFrameAssembler(size int) *FrameAssembler {
+	return &FrameAssembler{
+		size: size,
+		buf:  make([]byte, 0, size*2),
+	}
+}
+
+// Write appends the voice bytes to the assembler
+func (a *FrameAssembler) Write(p []byte) {
+	if a.off > 0 {
+		// move the rest to the head so that the buffer doesn't keep growing
+		n := copy(a.buf, a.buf[a.off:])
+		a.buf = a.buf[:n]
+		a.off = 0
+	}
+	a.buf = append(a.buf, p...)
+}
+
+// Next returns the next whole frame, or false if the bytes left are not enough for a frame.
+// The frame is valid until the next call of Write.
+func (a *FrameAssembler) Next() ([]byte, bool) {
+	if a.Buffered() < a.size {
+		return nil, false
+	}
+	frame := a.buf[a.off : a.off+a.size : a.off+a.size]
+	a.off += a.size
+	return frame, true
+}
+
+// Buffered returns the number of bytes which are not assembled into frames yet
+func (a *FrameAssembler) Buffered() int {
+	return len(a.buf) - a.off
+}
+
+// Flush returns the bytes left padded with silence to a whole frame, or false if nothing is left
+func (a *FrameAssembler) Flush() ([]byte, bool) {
+	if a.Buffered() == 0 {
+		return nil, false
+	}
+	frame := make([]byte, a.size)
+	copy(frame, a.buf[a.off:])
+	a.buf = a.buf[:0]
+	a.off = 0
+	return frame, true
+}
@@ -0,0 +1,82 @@
+package audio
+
+import (
+	"bytes"
+	"testing"
+)
+
+// seq returns n bytes counting from the start
+func seq(start, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(start + i)
+	}
+	return b
+}
+
+func TestFrameAssembler(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		chunks []int
+		frames int
+		flush  int
+	}{
+		{"whole frames", 320, []int{320, 640}, 3, 0},
+		{"480 bytes chunks", 320, []int{480, 480, 480}, 4, 160},
+		{"480 bytes chunks to whole frames", 320, []int{480, 480}, 3, 0},
+		{"odd length chunks", 320, []int{1, 333, 7, 299}, 2, 0},
+		{"odd length chunks with the rest", 640, []int{161, 161, 161}, 0, 483},
+		{"chunk larger than 2 frames", 320, []int{1000}, 3, 40},
+		{"empty chunks", 320, []int{0, 0}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewFrameAssembler(tt.size)
+			var in, out []byte
+			frames := 0
+			for _, n := range tt.chunks {
+				chunk := seq(len(in), n)
+				in = append(in, chunk...)
+				a.Write(chunk)
+				for {
+					frame, ok := a.Next()
+					if !ok {
+						break
+					}
+					if len(frame) != tt.size {
+						t.Fatalf("Next() = %v bytes, want %v", len(frame), tt.size)
+					}
+					out = append(out, frame...)
+					frames++
+				}
+			}
+			if frames != tt.frames {
+				t.Errorf("Next() returns %v frames, want %v", frames, tt.frames)
+			}
+			if n := a.Buffered(); n != tt.flush {
+				t.Errorf("Buffered() = %v, want %v", n, tt.flush)
+			}
+			frame, ok := a.Flush()
+			if ok != (tt.flush > 0) {
+				t.Fatalf("Flush() ok = %v, want %v", ok, tt.flush > 0)
+			}
+			if ok {
+				if len(frame) != tt.size {
+					t.Fatalf("Flush() = %v bytes, want %v", len(frame), tt.size)
+				}
+				// the rest is padded with silence
+				if pad := frame[tt.flush:]; !bytes.Equal(pad, make([]byte, len(pad))) {
+					t.Errorf("Flush() padding = %v, want silence", pad)
+				}
+				out = append(out, frame[:tt.flush]...)
+			}
+			if !bytes.Equal(out, in) {
+				t.Errorf("assembled bytes differ from the written ones")
+			}
+			if a.Buffered() != 0 {
+				t.Errorf("Buffered() = %v after Flush(), want 0", a.Buffered())
+			}
+		})
+	}
+}
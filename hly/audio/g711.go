@@ -0,0 +1,66 @@
+package audio
+
+import "encoding/binary"
+
+// alawTable and ulawTable map every 8 bits G.711 code to its 16 bits linear sample
+var (
+	alawTable [256]int16
+	ulawTable [256]int16
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		alawTable[i] = alaw2linear(byte(i))
+		ulawTable[i] = ulaw2linear(byte(i))
+	}
+}
+
+// DecodeALaw decodes G.711 A-law bytes to 16 bits linear pcm in little endian
+func DecodeALaw(in []byte) []byte {
+	return decode(in, &alawTable)
+}
+
+// DecodeULaw decodes G.711 μ-law bytes to 16 bits linear pcm in little endian
+func DecodeULaw(in []byte) []byte {
+	return decode(in, &ulawTable)
+}
+
+func decode(in []byte, table *[256]int16) []byte {
+	out := make([]byte, len(in)*2)
+	for i, c := range in {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(table[c]))
+	}
+	return out
+}
+
+// alaw2linear converts an A-law code to a linear sample as in ITU-T G.711
+func alaw2linear(c byte) int16 {
+	c ^= 0x55
+	t := int16(c&0x0f) << 4
+	seg := (c & 0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if c&0x80 != 0 {
+		return t
+	}
+	return -t
+}
+
+// ulaw2linear converts a μ-law code to a linear sample as in ITU-T G.711
+func ulaw2linear(c byte) int16 {
+	const bias = 0x84
+	c = ^c
+	t := (int16(c&0x0f) << 3) + bias
+	t <<= (c & 0x70) >> 4
+	if c&0x80 != 0 {
+		return bias - t
+	}
+	return t - bias
+}
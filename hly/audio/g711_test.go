@@ -0,0 +1,53 @@
+package audio
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeG711(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func([]byte) []byte
+		code   byte
+		want   int16
+	}{
+		// the values of the codewords in ITU-T G.711, scaled to 16 bits
+		{"alaw positive min", DecodeALaw, 0xd5, 8},
+		{"alaw negative min", DecodeALaw, 0x55, -8},
+		{"alaw positive segment 1", DecodeALaw, 0xc5, 264},
+		{"alaw positive segment 5", DecodeALaw, 0x80, 5504},
+		{"alaw negative segment 5", DecodeALaw, 0x00, -5504},
+		{"alaw positive max", DecodeALaw, 0xaa, 32256},
+		{"alaw negative max", DecodeALaw, 0x2a, -32256},
+		{"ulaw positive zero", DecodeULaw, 0xff, 0},
+		{"ulaw negative zero", DecodeULaw, 0x7f, 0},
+		{"ulaw positive segment 0", DecodeULaw, 0xf0, 120},
+		{"ulaw negative segment 0", DecodeULaw, 0x70, -120},
+		{"ulaw positive segment 4", DecodeULaw, 0xbf, 1980},
+		{"ulaw positive max", DecodeULaw, 0x80, 32124},
+		{"ulaw negative max", DecodeULaw, 0x00, -32124},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := tt.decode([]byte{tt.code})
+			if len(out) != 2 {
+				t.Fatalf("decode(0x%02x) returns %v bytes, want 2", tt.code, len(out))
+			}
+			if got := int16(binary.LittleEndian.Uint16(out)); got != tt.want {
+				t.Errorf("decode(0x%02x) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeG711Symmetric(t *testing.T) {
+	for c := 0; c < 128; c++ {
+		if a, b := alawTable[c], alawTable[c|0x80]; a != -b {
+			t.Errorf("alaw 0x%02x = %v and 0x%02x = %v, want symmetric", c, a, c|0x80, b)
+		}
+		if a, b := ulawTable[c], ulawTable[c|0x80]; a != -b {
+			t.Errorf("ulaw 0x%02x = %v and 0x%02x = %v, want symmetric", c, a, c|0x80, b)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// zeroCrossings is the number of zero crossings of the sinc on each side of the filter
+	zeroCrossings = 16
+
+	// rolloff is the ratio of the cutoff frequency to the nyquist frequency of the lower rate
+	rolloff = 0.9
+)
+
+// MaxFilterSize is the max number of the filter coefficients of a resampler, which is 512KB.
+// The filter of the rates without a large common divisor is too large, e.g. 55MB from 191999 to 16000.
+const MaxFilterSize = 1 << 16
+
+// Resampler converts 16 bits linear pcm in little endian from one sample rate to another
+// by a polyphase windowed-sinc filter. The filter history is kept between calls, so that
+// a voice stream can be resampled chunk by chunk without clicks on the chunk boundaries.
+// It is not safe for concurrent use.
+type Resampler struct {
+	inRate  int
+	outRate int
+
+	// up and down are the interpolation and decimation factors (L and M)
+	up   int
+	down int
+
+	// taps is the number of filter taps per phase
+	taps int
+
+	// coeffs are the filter coefficients arranged by phase, coeffs[p][j] = h[p + j*up]
+	coeffs [][]float64
+
+	// buf contains the history samples followed by the samples to be resampled
+	buf []float64
+
+	// pos is the position of the next output sample in the upsampled domain,
+	// relative to the first sample in buf
+	pos int
+
+	// odd is the trailing byte of the last input which is not a whole sample
+	odd []byte
+}
+
+// NewResampler creates a resampler from inRate to outRate
+func NewResampler(inRate, outRate int) (*Resampler, error) {
+	if inRate <= 0 || outRate <= 0 {
+		return nil, fmt.Errorf("resampler error - rates should be greater than 0, got %v and %v", inRate, outRate)
+	}
+	g := gcd(inRate, outRate)
+	up := outRate / g
+	down := inRate / g
+
+	// cutoff at the nyquist frequency of the lower rate, normalized to the upsampled rate
+	cutoff := rolloff * 0.5 * float64(min(inRate, outRate)) / float64(inRate*up)
+	taps := int(math.Ceil(zeroCrossings / cutoff / float64(up)))
+	size := taps * up
+	if size > MaxFilterSize {
+		return nil, fmt.Errorf("resampler error - unsupported rates from %v to %v, the filter size %v exceeds %v", inRate, outRate, size, MaxFilterSize)
+	}
+	center := float64(size-1) / 2
+
+	coeffs := make([][]float64, up)
+	for p := range coeffs {
+		coeffs[p] = make([]float64, taps)
+	}
+	for i := 0; i < size; i++ {
+		x := float64(i) - center
+		h := 2 * cutoff * sinc(2*cutoff*x) * blackman(i, size) * float64(up)
+		coeffs[i%up][i/up] = h
+	}
+
+	r := &Resampler{
+		inRate:  inRate,
+		outRate: outRate,
+		up:      up,
+		down:    down,
+		taps:    taps,
+		coeffs:  coeffs,
+		// zero history before the stream starts
+		buf: make([]float64, taps-1),
+	}
+	// delay the output by the group delay of the filter to align it with the input
+	r.pos = (taps-1)*up + (size-1)/2
+	return r, nil
+}
+
+// InRate returns the sample rate of the input
+func (r *Resampler) InRate() int {
+	return r.inRate
+}
+
+// FilterSize returns the number of the filter coefficients
+func (r *Resampler) FilterSize() int {
+	return r.taps * r.up
+}
+
+// OutRate returns the sample rate of the output
+func (r *Resampler) OutRate() int {
+	return r.outRate
+}
+
+// Process resamples the pcm bytes and returns the resampled pcm bytes which are available so far
+func (r *Resampler) Process(in []byte) []byte {
+	if len(r.odd) > 0 {
+		in = append(r.odd, in...)
+		r.odd = nil
+	}
+	n := len(in) / 2
+	if len(in)%2 != 0 {
+		r.odd = []byte{in[len(in)-1]}
+	}
+	for i := 0; i < n; i++ {
+		r.buf = append(r.buf, float64(int16(binary.LittleEndian.Uint16(in[i*2:]))))
+	}
+
+	out := make([]byte, 0, n*r.up/r.down*2+2)
+	for {
+		n0 := r.pos / r.up
+		if n0 >= len(r.buf) {
+			break
+		}
+		phase := r.coeffs[r.pos%r.up]
+		var y float64
+		for j, h := range phase {
+			y += h * r.buf[n0-j]
+		}
+		out = append(out, 0, 0)
+		binary.LittleEndian.PutUint16(out[len(out)-2:], uint16(clamp(y)))
+		r.pos += r.down
+	}
+
+	// drop the samples which are not needed as history any more
+	drop := r.pos/r.up - (r.taps - 1)
+	if drop > 0 {
+		if drop > len(r.buf) {
+			drop = len(r.buf)
+		}
+		r.buf = append(r.buf[:0], r.buf[drop:]...)
+		r.pos -= drop * r.up
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+func blackman(i, size int) float64 {
+	if size <= 1 {
+		return 1
+	}
+	a := 2 * math.Pi * float64(i) / float64(size-1)
+	return 0.42 - 0.5*math.Cos(a) + 0.08*math.Cos(2*a)
+}
+
+func clamp(y float64) int16 {
+	y = math.Round(y)
+	if y > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if y < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(y)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
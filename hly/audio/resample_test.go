@@ -0,0 +1,117 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// pcm returns n samples of 16 bits linear pcm with the value
+func pcm(n int, value int16) []byte {
+	b := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(value))
+	}
+	return b
+}
+
+// resample resamples the pcm chunk by chunk in the size and returns all the output
+func resample(t *testing.T, inRate, outRate int, in []byte, size int) []byte {
+	r, err := NewResampler(inRate, outRate)
+	if err != nil {
+		t.Fatalf("NewResampler(%v, %v) error = %v", inRate, outRate, err)
+	}
+	var out []byte
+	for len(in) > 0 {
+		n := size
+		if n > len(in) {
+			n = len(in)
+		}
+		out = append(out, r.Process(in[:n])...)
+		in = in[n:]
+	}
+	return out
+}
+
+func TestResampler(t *testing.T) {
+	tests := []struct {
+		inRate  int
+		outRate int
+	}{
+		{8000, 16000},
+		{16000, 8000},
+		{44100, 16000},
+		{48000, 16000},
+		{11025, 8000},
+		{32000, 16000},
+	}
+	const value = 10000
+	for _, tt := range tests {
+		r, err := NewResampler(tt.inRate, tt.outRate)
+		if err != nil {
+			t.Fatalf("NewResampler(%v, %v) error = %v", tt.inRate, tt.outRate, err)
+		}
+		g := gcd(tt.inRate, tt.outRate)
+		up, down := tt.outRate/g, tt.inRate/g
+		// the output is delayed by the half of the filter to align it with the input
+		n := tt.inRate // 1 second
+		want := int(math.Ceil(float64(n*up-(r.FilterSize()-1)/2) / float64(down)))
+		in := pcm(n, value)
+		whole := r.Process(in)
+		if got := len(whole) / 2; got != want {
+			t.Errorf("%v to %v output %v samples, want %v", tt.inRate, tt.outRate, got, want)
+		}
+
+		// the dc gain is 1 once the filter is filled with the input
+		for i := r.FilterSize() / up; i < len(whole)/2; i++ {
+			if v := int16(binary.LittleEndian.Uint16(whole[i*2:])); math.Abs(float64(v-value)) > value/100 {
+				t.Errorf("%v to %v sample %v = %v, want %v", tt.inRate, tt.outRate, i, v, value)
+				break
+			}
+		}
+
+		// the chunk boundaries make no difference, including the ones which split a sample
+		for _, size := range []int{320, 321, 160, 7, 1} {
+			if got := resample(t, tt.inRate, tt.outRate, in, size); !bytes.Equal(got, whole) {
+				t.Errorf("%v to %v in chunks of %v bytes differs from the whole, got %v bytes, want %v", tt.inRate, tt.outRate, size, len(got), len(whole))
+			}
+		}
+	}
+}
+
+func TestResamplerOddByte(t *testing.T) {
+	r, err := NewResampler(8000, 16000)
+	if err != nil {
+		t.Fatalf("NewResampler() error = %v", err)
+	}
+	in := pcm(400, -1234)
+	out := r.Process(in[:401])
+	if len(r.odd) != 1 || r.odd[0] != in[400] {
+		t.Fatalf("odd byte = %v, want [%v]", r.odd, in[400])
+	}
+	out = append(out, r.Process(in[401:])...)
+	if len(r.odd) != 0 {
+		t.Errorf("odd byte = %v after the whole samples, want none", r.odd)
+	}
+	if want := resample(t, 8000, 16000, in, len(in)); !bytes.Equal(out, want) {
+		t.Errorf("output with the odd byte carried over differs from the whole")
+	}
+}
+
+func TestNewResamplerError(t *testing.T) {
+	tests := []struct {
+		inRate  int
+		outRate int
+	}{
+		{0, 16000},
+		{8000, -1},
+		{191999, 16000},
+		{176401, 8000},
+	}
+	for _, tt := range tests {
+		if _, err := NewResampler(tt.inRate, tt.outRate); err == nil {
+			t.Errorf("NewResampler(%v, %v) error = nil, want error", tt.inRate, tt.outRate)
+		}
+	}
+}
@@ -4,13 +4,60 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/url"
+	"strconv"
 
 	ws "github.com/gorilla/websocket"
 	wav "github.com/henryleu/go-wav"
 )
 
+// chunkDuration is the duration in milliseconds of the audio in a chunk sent by client
+const chunkDuration = 80
+
+const (
+	// wavFormatALaw is the format type of G.711 A-law wav files
+	wavFormatALaw = 6
+
+	// wavFormatULaw is the format type of G.711 μ-law wav files
+	wavFormatULaw = 7
+)
+
 // ClientRequest is the handler for nlp+vad
 func ClientRequest(url, fn string) {
+	ClientRequestWithMode(url, fn, ModeSingle)
+}
+
+// ClientRequestWithMode is the handler for nlp+vad in the specific detecting mode.
+func ClientRequestWithMode(url, fn, mode string) {
+	ClientRequestWithOptions(url, fn, &ClientOptions{Mode: mode})
+}
+
+// ClientOptions are the options of the client request
+type ClientOptions struct {
+	// Mode is the detecting mode, single or multiple
+	Mode string
+
+	// Transfer is the way to send audio, json or binary
+	Transfer string
+
+	// ChunkSize is the bytes of the audio in a chunk, and the audio of chunkDuration is sent if it is 0
+	ChunkSize int
+
+	// Profile is the name of the profile in the server config, or empty to be selected by the server
+	Profile string
+
+	// VAD is the vad params overriding the default ones of the server, or nil to use the default ones
+	VAD *VAD
+}
+
+// ClientRequestWithOptions is the handler for nlp+vad with the options.
+// In multiple mode, it keeps receiving responses until the call is hung up or
+// the server closes the session.
+func ClientRequestWithOptions(url, fn string, opts *ClientOptions) {
+	codec, err := codecOfURL(url)
+	if err != nil {
+		log.Fatal("codec:", err)
+	}
 	c, _, err := ws.DefaultDialer.Dial(url, nil)
 	if err != nil {
 		log.Fatal("dial:", err)
@@ -22,7 +69,8 @@ func ClientRequest(url, fn string) {
 	//	log.Println("client conn is closed")
 	//}()
 
-	wire := NewWire(c)
+	wire := NewWireWithCodec(c, codec)
+	wire.SetEnvelope(true)
 	done := make(chan struct{})
 	var errMsg string
 
@@ -45,22 +93,55 @@ func ClientRequest(url, fn string) {
 						break loop_response
 					}
 					log.Printf("succeed to get response: %v\n", res)
+					if opts.Mode == ModeMultiple && res.Result.Code == 1 && res.Result.Return.Control.Status == 0 {
+						continue
+					}
 					wire.SendCloseMessage(ws.CloseNormalClosure, "")
 					break loop_response
+				case EventType:
+					evt, err := msg.Event()
+					if err != nil {
+						log.Printf("fail to get event msg, error = %v\n", err)
+						break loop_response
+					}
+					log.Printf("succeed to get event %v at %vms\n", evt.Event, evt.Offset)
 				default:
-					log.Printf("client can only receive response msg, but got %v message\n", msg.Type)
+					log.Printf("client can only receive response and event msg, but got %v message\n", msg.Type)
 					break loop_response
 				}
 			case err := <-wire.ErrCh:
 				log.Printf("fail to receive response msg, error = %v\n", err)
 				break loop_response
+			case <-wire.Closed:
+				if len(wire.MsgCh) > 0 {
+					// handle the messages received before closing
+					continue
+				}
+				log.Println("session is closed by server")
+				break loop_response
 			}
 		}
 	}()
 
+	r, err := wav.NewReaderFromFile(fn)
+	if err != nil {
+		errMsg = fmt.Sprintf("wav.NewReaderFromFile() error = %v\n", err)
+		log.Print(errMsg)
+		wire.SendCloseMessage(ws.CloseUnsupportedData, errMsg)
+		return
+	}
+	rate := int(r.FmtChunk.Data.SamplesPerSec)
+	encoding, bytesPerSample := encodingOfWav(r.FmtChunk.Data)
+
 	req := &Request{
-		CID:  "01010101010",
-		Rate: "8000",
+		CID:      "01010101010",
+		Rate:     strconv.Itoa(rate),
+		Mode:     opts.Mode,
+		Events:   true,
+		Transfer: opts.Transfer,
+		Encoding: encoding,
+		Profile:  opts.Profile,
+		VAD:      opts.VAD,
 		Business: &Business{
 			UID:      "1331114444 abcd",
 			Province: "beijing",
@@ -75,15 +156,11 @@ func ClientRequest(url, fn string) {
 		log.Fatalf("Wire.Send(requestMsg) error = %v", err)
 	}
 
-	r, err := wav.NewReaderFromFile(fn)
-	if err != nil {
-		errMsg = fmt.Sprintf("wav.NewReaderFromFile() error = %v\n", err)
-		log.Print(errMsg)
-		wire.SendCloseMessage(ws.CloseUnsupportedData, errMsg)
-		return
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = rate / 1000 * bytesPerSample * chunkDuration // 1280 bytes in 8k
 	}
-
-	frame := make([]byte, 1280)
+	frame := make([]byte, chunkSize)
 	i := 0
 
 send_chunk:
@@ -111,8 +188,12 @@ send_chunk:
 			NO:   i,
 			Data: frame,
 		}
-		chunk.EncodeAudio()
-		err = wire.Send(chunk.Message())
+		if req.Binary() {
+			err = wire.Send(chunk.AudioMessage())
+		} else {
+			chunk.EncodeAudio()
+			err = wire.Send(chunk.Message())
+		}
 		if err != nil {
 			errMsg = fmt.Sprintf("Wire.Send(chunkMsg) error = %v", err)
 			log.Print(errMsg)
@@ -121,5 +202,43 @@ send_chunk:
 	}
 
 	log.Println("client is done")
-	wire.SendCloseMessage(ws.CloseUnsupportedData, "")
+	if errMsg != "" {
+		wire.SendCloseMessage(ws.CloseUnsupportedData, "")
+		return
+	}
+	select {
+	case <-done:
+		return
+	default:
+	}
+
+	// end the stream and wait for the responses of the rest utterances
+	eos := &EndOfStream{CID: req.CID}
+	err = wire.Send(eos.Message())
+	if err != nil {
+		log.Printf("Wire.Send(eosMsg) error = %v", err)
+		return
+	}
+	<-done
+}
+
+// encodingOfWav returns the encoding of the request and the bytes per sample by the wav format
+func encodingOfWav(data *wav.FmtChunkData) (string, int) {
+	switch data.WaveFormatType {
+	case wavFormatALaw:
+		return EncodingALaw, 1
+	case wavFormatULaw:
+		return EncodingULaw, 1
+	default:
+		return EncodingPCM16, 2
+	}
+}
+
+// codecOfURL creates the codec specified by the codec query parameter of the url
+func codecOfURL(rawurl string) (Codec, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return NewCodec(u.Query().Get("codec"))
 }
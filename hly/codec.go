@@ -0,0 +1,103 @@
+package hly
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// PlainCodec sends every message as a bare websocket frame
+	PlainCodec = "plain"
+
+	// FramedCodec prefixes every message with its length in 2 bytes network byte order,
+	// which is the data format described in the protocol spec.
+	FramedCodec = "framed"
+)
+
+// frameHeaderLen is the length of the header of a length-prefixed frame
+const frameHeaderLen = 2
+
+// maxFramedLen is the max length of the message in a length-prefixed frame
+const maxFramedLen = 1<<16 - 1
+
+// Codec encodes messages to frames on the wire and decodes frames back to messages
+type Codec interface {
+	// Name returns the name of the codec
+	Name() string
+
+	// Encode wraps the message bytes into a frame
+	Encode(msg []byte) ([]byte, error)
+
+	// Decode unwraps the message bytes from a frame
+	Decode(frame []byte) ([]byte, error)
+}
+
+// NewCodec creates a codec by name, and the plain codec is used if name is empty
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", PlainCodec:
+		return plainCodec{}, nil
+	case FramedCodec:
+		return framedCodec{}, nil
+	default:
+		return nil, fmt.Errorf("codec error - illegal codec %q, it should be %q or %q", name, PlainCodec, FramedCodec)
+	}
+}
+
+type plainCodec struct{}
+
+func (plainCodec) Name() string {
+	return PlainCodec
+}
+
+func (plainCodec) Encode(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+func (plainCodec) Decode(frame []byte) ([]byte, error) {
+	return frame, nil
+}
+
+type framedCodec struct{}
+
+func (framedCodec) Name() string {
+	return FramedCodec
+}
+
+func (framedCodec) Encode(msg []byte) ([]byte, error) {
+	l := len(msg)
+	if l > maxFramedLen {
+		return nil, fmt.Errorf("codec error - message length %v exceeds the max frame length %v", l, maxFramedLen)
+	}
+	frame := make([]byte, frameHeaderLen+l)
+	binary.BigEndian.PutUint16(frame, uint16(l))
+	copy(frame[frameHeaderLen:], msg)
+	return frame, nil
+}
+
+func (framedCodec) Decode(frame []byte) ([]byte, error) {
+	if len(frame) < frameHeaderLen {
+		return nil, fmt.Errorf("codec error - frame is too short, got %v bytes", len(frame))
+	}
+	l := int(binary.BigEndian.Uint16(frame))
+	if l != len(frame)-frameHeaderLen {
+		return nil, fmt.Errorf("codec error - frame length mismatched, want %v, got %v", l, len(frame)-frameHeaderLen)
+	}
+	return frame[frameHeaderLen:], nil
+}
+
+// ReadFramed reads a length-prefixed frame from the stream and returns the message in it
+func ReadFramed(r io.Reader) ([]byte, error) {
+	header := make([]byte, frameHeaderLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(header))
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
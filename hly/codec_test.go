@@ -0,0 +1,111 @@
+package hly
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// framed returns the frame with the length in the header and the body
+func framed(length int, body []byte) []byte {
+	frame := make([]byte, frameHeaderLen, frameHeaderLen+len(body))
+	binary.BigEndian.PutUint16(frame, uint16(length))
+	return append(frame, body...)
+}
+
+func TestFramedCodecEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		len  int
+		ok   bool
+	}{
+		{"empty", 0, true},
+		{"one byte", 1, true},
+		{"max length", maxFramedLen, true},
+		{"over max length", maxFramedLen + 1, false},
+	}
+	c := framedCodec{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := bytes.Repeat([]byte{'a'}, tt.len)
+			frame, err := c.Encode(msg)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Encode() error = %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if want := framed(tt.len, msg); !bytes.Equal(frame, want) {
+				t.Errorf("Encode() header = % x, want % x", frame[:frameHeaderLen], want[:frameHeaderLen])
+			}
+			got, err := c.Decode(frame)
+			if err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("Decode(Encode()) = %v bytes, error = %v, want %v bytes", len(got), err, tt.len)
+			}
+			got, err = ReadFramed(bytes.NewReader(frame))
+			if err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("ReadFramed(Encode()) = %v bytes, error = %v, want %v bytes", len(got), err, tt.len)
+			}
+		})
+	}
+}
+
+func TestFramedCodecDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  []byte
+		ok    bool
+	}{
+		{"empty", nil, nil, false},
+		{"short header", []byte{0}, nil, false},
+		{"empty body", framed(0, nil), []byte{}, true},
+		{"body", framed(3, []byte("abc")), []byte("abc"), true},
+		{"body shorter than length", framed(3, []byte("ab")), nil, false},
+		{"body longer than length", framed(1, []byte("ab")), nil, false},
+		{"max length with short body", framed(maxFramedLen, []byte("ab")), nil, false},
+	}
+	c := framedCodec{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Decode(tt.frame)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Decode() error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !bytes.Equal(got, tt.want) {
+				t.Errorf("Decode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFramedTruncated(t *testing.T) {
+	for _, frame := range [][]byte{{}, {0}, framed(3, []byte("ab"))} {
+		if _, err := ReadFramed(bytes.NewReader(frame)); err == nil {
+			t.Errorf("ReadFramed(% x) error = nil, want error", frame)
+		}
+	}
+}
+
+func TestNewCodec(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"", PlainCodec, true},
+		{PlainCodec, PlainCodec, true},
+		{FramedCodec, FramedCodec, true},
+		{"gzip", "", false},
+	}
+	for _, tt := range tests {
+		c, err := NewCodec(tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewCodec(%q) error = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && c.Name() != tt.want {
+			t.Errorf("NewCodec(%q).Name() = %q, want %q", tt.name, c.Name(), tt.want)
+		}
+	}
+}
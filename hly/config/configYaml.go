@@ -6,3 +6,379 @@ package config
 @File : configYaml
 @Software: GoLand
 */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/henryleu/go-vad"
+	"github.com/henryleu/vads/hly/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of the server loaded from a yaml file, e.g.
+//
+//	server:
+//	  listen: 0.0.0.0:6000
+//	  request_timeout: 30s
+//	vad:
+//	  silence_timeout: 400
+//	asr:
+//	  aliyun:
+//	    app_key_file: /run/secrets/aliyun_app_key
+//
+// The scalar fields can be overridden by the env vars named by their yaml paths in upper case
+// with the prefix VADS, e.g. VADS_SERVER_LISTEN and VADS_ASR_ALIYUN_APP_KEY. The maps and
+// slices, e.g. profiles, rules, server.channel_max_sessions and asr.http.headers, can be set
+// in the yaml file only.
+type Config struct {
+	Server Server `yaml:"server"`
+	VAD    VAD    `yaml:"vad"`
+	ASR    ASR    `yaml:"asr"`
+	Flow   Flow   `yaml:"flow"`
+	Health Health `yaml:"health"`
+	Log    Log    `yaml:"log"`
+
+	// Profiles are the named vad params and asr engines for tenants
+	Profiles map[string]*Profile `yaml:"profiles"`
+
+	// Rules select the profile for the session in order
+	Rules []Rule `yaml:"rules"`
+}
+
+// Server is the configuration of the listeners and sessions
+type Server struct {
+	// Listen is the address of the websocket service
+	Listen string `yaml:"listen"`
+
+	// TCPListen is the address of the raw tcp service, which is disabled if empty
+	TCPListen string `yaml:"tcp_listen"`
+
+	// RequestTimeout is the timeout to receive the request after connected
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// ChunkTimeout is the timeout to receive the next chunk
+	ChunkTimeout time.Duration `yaml:"chunk_timeout"`
+
+	// VoiceDir is the dir to save the clip files, which is tmp in the working dir if empty
+	VoiceDir string `yaml:"voice_dir"`
+
+	// ShutdownTimeout is the time to wait for the active sessions to finish on shutting down,
+	// and the sessions not finished are closed after it.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// MaxSessions is the max number of the concurrent sessions, including the connections
+	// waiting for the request, and it is unlimited if 0
+	MaxSessions int `yaml:"max_sessions"`
+
+	// MaxSessionsPerChannel is the max number of the concurrent sessions of each channel,
+	// and it is unlimited if 0
+	MaxSessionsPerChannel int `yaml:"max_sessions_per_channel"`
+
+	// ChannelMaxSessions overrides MaxSessionsPerChannel for the specified channels, e.g.
+	//
+	//	channel_max_sessions:
+	//	  "03": 50
+	ChannelMaxSessions map[string]int `yaml:"channel_max_sessions"`
+
+	// AdminListen is the address of the admin endpoints, e.g. /admin/reload and /admin/sessions,
+	// which is separated from the public listener and disabled if empty
+	AdminListen string `yaml:"admin_listen"`
+
+	// AdminToken is the bearer token required by the admin endpoints, which is read from
+	// AdminTokenFile if empty, e.g. a mounted secret. It is required unless AdminListen is
+	// a loopback address.
+	AdminToken     string `yaml:"admin_token"`
+	AdminTokenFile string `yaml:"admin_token_file"`
+
+	// LegacyCompatible indicates if the un-enveloped messages of legacy clients are accepted.
+	// In legacy mode, the first message from client is a request and the later ones are chunks,
+	// and the server responses to legacy clients in un-enveloped messages as well.
+	LegacyCompatible bool `yaml:"legacy_compatible"`
+}
+
+// AdminAuth checks if the admin endpoints require the token
+func (s *Server) AdminAuth() bool {
+	return s.AdminToken != "" || s.AdminTokenFile != ""
+}
+
+// ChannelLimit returns the max number of the concurrent sessions of the channel, or 0 if unlimited
+func (s *Server) ChannelLimit(channel string) int {
+	if n, ok := s.ChannelMaxSessions[channel]; ok {
+		return n
+	}
+	return s.MaxSessionsPerChannel
+}
+
+// VAD is the default detector configuration of the sessions in milliseconds
+type VAD struct {
+	SpeechTimeout      int `yaml:"speech_timeout"`
+	SilenceTimeout     int `yaml:"silence_timeout"`
+	NoinputTimeout     int `yaml:"noinput_timeout"`
+	RecognitionTimeout int `yaml:"recognition_timeout"`
+	VADLevel           int `yaml:"vad_level"`
+}
+
+// ASR is the configuration of the speech recognition services
+type ASR struct {
+	// Engine is the default asr engine, aliyun, aicyber, http or the one registered by asr.Register
+	Engine string `yaml:"engine"`
+
+	// Timeout is the timeout of a recognition
+	Timeout time.Duration `yaml:"timeout"`
+
+	Aliyun  Aliyun  `yaml:"aliyun"`
+	Aicyber Aicyber `yaml:"aicyber"`
+	HTTP    HTTP    `yaml:"http"`
+}
+
+// Aliyun is the configuration of the aliyun speech recognition service
+type Aliyun struct {
+	// URL is the endpoint of the recognition
+	URL string `yaml:"url"`
+
+	// Region is the region of the token service
+	Region string `yaml:"region"`
+
+	// TokenDomain is the domain of the token service
+	TokenDomain string `yaml:"token_domain"`
+
+	// AppKey is the app key of the recognition
+	AppKey string `yaml:"app_key"`
+
+	// AccessKeyID and AccessKeySecret are the credentials to create tokens
+	AccessKeyID     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"access_key_secret"`
+
+	// AppKeyFile, AccessKeyIDFile and AccessKeySecretFile are the files of the credentials,
+	// e.g. the mounted secrets, which are used if the credentials are not set, and read
+	// again once they are modified
+	AppKeyFile          string `yaml:"app_key_file"`
+	AccessKeyIDFile     string `yaml:"access_key_id_file"`
+	AccessKeySecretFile string `yaml:"access_key_secret_file"`
+}
+
+// Aicyber is the configuration of the aicyber speech recognition service
+type Aicyber struct {
+	URL string `yaml:"url"`
+}
+
+// HTTP is the configuration of the generic http speech recognition service, which receives
+// the audio as the body of a POST request and responds the text in a json field, e.g.
+//
+//	http:
+//	  url: http://127.0.0.1:8080/recognize
+//	  content_type: audio/wav;rate={rate}
+//	  text_field: data.0.text
+//	  headers:
+//	    Authorization: Bearer xxxx
+type HTTP struct {
+	URL string `yaml:"url"`
+
+	// ContentType is the content type of the audio, in which {format} and {rate} are replaced
+	// by the format and the sample rate of the audio
+	ContentType string `yaml:"content_type"`
+
+	// TextField is the path of the text in the json response, whose elements are separated
+	// by dots and the array elements are indexed by numbers, e.g. result.text or data.0.text
+	TextField string `yaml:"text_field"`
+
+	// Headers are the extra headers of the request
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Flow is the configuration of the dialog flow service
+type Flow struct {
+	SayURL    string `yaml:"say_url"`
+	ClosedURL string `yaml:"closed_url"`
+	InfoURL   string `yaml:"info_url"`
+}
+
+// Health is the configuration of the readiness probes of the asr services
+type Health struct {
+	// ProbeTimeout is the timeout to connect to a service
+	ProbeTimeout time.Duration `yaml:"probe_timeout"`
+
+	// ProbeInterval is the time the probe result of a service is cached for
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+}
+
+// Log is the configuration of the logging
+type Log struct {
+	// Level is the min level of the lines logged, debug, info, warn or error
+	Level string `yaml:"level"`
+
+	// Format is the output format, json or text
+	Format string `yaml:"format"`
+}
+
+// Apply sets the level and the format of the logging
+func (l *Log) Apply() error {
+	return logging.Configure(l.Level, l.Format)
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Listen:           "0.0.0.0:6000",
+			RequestTimeout:   30 * time.Second,
+			ChunkTimeout:     2 * time.Second,
+			ShutdownTimeout:  30 * time.Second,
+			AdminListen:      "127.0.0.1:6001",
+			LegacyCompatible: true,
+		},
+		VAD: VAD{
+			SpeechTimeout:      400,   // 800 is the best value, test it before changing
+			SilenceTimeout:     400,   // 800 is the best value, test it before changing
+			NoinputTimeout:     20000, // nearly ignore noinput case
+			RecognitionTimeout: 10000,
+			VADLevel:           2, // 3 is the best value, test it before changing
+		},
+		ASR: ASR{
+			Engine:  EngineAliyun,
+			Timeout: 10 * time.Second,
+			Aliyun: Aliyun{
+				URL:         "http://nls-gateway.cn-shanghai.aliyuncs.com/stream/v1/asr",
+				Region:      "cn-shanghai",
+				TokenDomain: "nls-meta.cn-shanghai.aliyuncs.com",
+			},
+			Aicyber: Aicyber{
+				URL: "http://192.168.2.200:8080/aicyber/asr/gpu/stream/gpu/recognise/",
+			},
+			HTTP: HTTP{
+				ContentType: "audio/{format};rate={rate}",
+				TextField:   "text",
+			},
+		},
+		Flow: Flow{
+			SayURL:    "http://114.116.238.23/robot/say.do",
+			ClosedURL: "http://114.116.238.23/robot/closed.do",
+			InfoURL:   "http://call-aid.aimango.net/api/hly/flow/get",
+		},
+		Health: Health{
+			ProbeTimeout:  2 * time.Second,
+			ProbeInterval: 10 * time.Second,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: logging.FormatJSON,
+		},
+	}
+}
+
+// Load loads the configuration from the yaml file over the default one, overrides it
+// with the env vars and validates it. Only the default and env vars are used if path is empty.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config error - fail to read %v, error: %v", path, err)
+		}
+		err = yaml.UnmarshalStrict(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("config error - fail to parse %v, error: %v", path, err)
+		}
+	}
+	err := c.LoadEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks if the configuration is valid
+func (c *Config) Validate() error {
+	if c.Server.Listen == "" {
+		return fmt.Errorf("config error - server.listen should not be empty")
+	}
+	if c.Server.AdminListen != "" {
+		host, _, err := net.SplitHostPort(c.Server.AdminListen)
+		if err != nil {
+			return fmt.Errorf("config error - illegal server.admin_listen %q, error: %v", c.Server.AdminListen, err)
+		}
+		if ip := net.ParseIP(host); (ip == nil || !ip.IsLoopback()) && host != "localhost" && !c.Server.AdminAuth() {
+			return fmt.Errorf("config error - server.admin_token or server.admin_token_file should be set since server.admin_listen %q is not loopback", c.Server.AdminListen)
+		}
+		if c.Server.AdminAuth() {
+			if _, err := Credential("server.admin_token", c.Server.AdminToken, c.Server.AdminTokenFile); err != nil {
+				return err
+			}
+		}
+	}
+	if c.Server.RequestTimeout <= 0 {
+		return fmt.Errorf("config error - server.request_timeout should be greater than 0, got %v", c.Server.RequestTimeout)
+	}
+	if c.Server.ChunkTimeout <= 0 {
+		return fmt.Errorf("config error - server.chunk_timeout should be greater than 0, got %v", c.Server.ChunkTimeout)
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		return fmt.Errorf("config error - server.shutdown_timeout should not be negative, got %v", c.Server.ShutdownTimeout)
+	}
+	if c.Server.MaxSessions < 0 {
+		return fmt.Errorf("config error - server.max_sessions should not be negative, got %v", c.Server.MaxSessions)
+	}
+	if c.Server.MaxSessionsPerChannel < 0 {
+		return fmt.Errorf("config error - server.max_sessions_per_channel should not be negative, got %v", c.Server.MaxSessionsPerChannel)
+	}
+	for channel, n := range c.Server.ChannelMaxSessions {
+		if n < 0 {
+			return fmt.Errorf("config error - server.channel_max_sessions.%v should not be negative, got %v", channel, n)
+		}
+	}
+	vc := vad.NewDefaultConfig()
+	c.VAD.Apply(vc)
+	if err := vc.Validate(); err != nil {
+		return fmt.Errorf("config error - vad is invalid, error: %v", err)
+	}
+	if c.ASR.Timeout <= 0 {
+		return fmt.Errorf("config error - asr.timeout should be greater than 0, got %v", c.ASR.Timeout)
+	}
+	if c.Health.ProbeTimeout <= 0 {
+		return fmt.Errorf("config error - health.probe_timeout should be greater than 0, got %v", c.Health.ProbeTimeout)
+	}
+	if c.Health.ProbeInterval < 0 {
+		return fmt.Errorf("config error - health.probe_interval should not be negative, got %v", c.Health.ProbeInterval)
+	}
+	if err := logging.Validate(c.Log.Level, c.Log.Format); err != nil {
+		return fmt.Errorf("config error - log is invalid, error: %v", err)
+	}
+	if err := c.validateProfiles(); err != nil {
+		return err
+	}
+	return c.validateEngines()
+}
+
+// Apply sets the vad params to the detector config
+func (c *VAD) Apply(vc *vad.Config) {
+	vc.SpeechTimeout = c.SpeechTimeout
+	vc.SilenceTimeout = c.SilenceTimeout
+	vc.NoinputTimeout = c.NoinputTimeout
+	vc.RecognitionTimeout = c.RecognitionTimeout
+	vc.VADLevel = vad.Level(c.VADLevel)
+}
+
+// current is the configuration in use
+var current atomic.Value
+
+// Current returns the configuration in use, which is the default one if none is set
+func Current() *Config {
+	c, ok := current.Load().(*Config)
+	if !ok {
+		return Default()
+	}
+	return c
+}
+
+// Set sets the configuration in use, and it should not be modified after set
+func Set(c *Config) {
+	current.Store(c)
+}
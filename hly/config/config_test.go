@@ -0,0 +1,207 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig returns a valid config using the http asr service, which needs no credential
+func testConfig() *Config {
+	c := Default()
+	c.ASR.Engine = EngineHTTP
+	c.ASR.HTTP.URL = "http://127.0.0.1:8080/recognize"
+	return c
+}
+
+// writeFile writes the content to a file in a temp dir removed after the test
+func writeFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "vads-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setenv sets the env var until the test is over
+func setenv(t *testing.T, name, value string) {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+server:
+  listen: 0.0.0.0:7000
+  channel_max_sessions:
+    "03": 5
+vad:
+  silence_timeout: 300
+asr:
+  engine: http
+  http:
+    url: http://127.0.0.1:8080/recognize
+profiles:
+  yesno:
+    vad:
+      silence_timeout: 200
+    asr: aicyber
+rules:
+  - profile: yesno
+    channel: "03"
+`)
+	setenv(t, "VADS_VAD_VAD_LEVEL", "3")
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.Server.Listen != "0.0.0.0:7000" || c.Server.ChannelLimit("03") != 5 {
+		t.Errorf("server = %+v, want the values in the file", c.Server)
+	}
+	if c.Server.RequestTimeout != 30*time.Second || !c.Server.LegacyCompatible {
+		t.Errorf("server = %+v, want the default values not in the file", c.Server)
+	}
+	if c.VAD.SilenceTimeout != 300 || c.VAD.VADLevel != 3 {
+		t.Errorf("vad = %+v, want silence_timeout in the file and vad_level by env", c.VAD)
+	}
+	if p, ok := c.Profile("yesno"); !ok || *p.VAD.SilenceTimeout != 200 || c.Engine(p) != EngineAicyber {
+		t.Errorf("profile yesno = %+v, want the one in the file", p)
+	}
+	if name := c.SelectProfile("03", "", ""); name != "yesno" {
+		t.Errorf("SelectProfile(03) = %q, want yesno", name)
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		want    string
+	}{
+		{"unknown field", "server:\n  listen_addr: 0.0.0.0:7000\n", nil, "fail to parse"},
+		{"illegal yaml", "server: [\n", nil, "fail to parse"},
+		{"illegal env", "", map[string]string{"VADS_SERVER_REQUEST_TIMEOUT": "30"}, "VADS_SERVER_REQUEST_TIMEOUT"},
+		{"invalid", "server:\n  max_sessions: -1\n", nil, "server.max_sessions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "VADS_ASR_ENGINE", EngineHTTP)
+			setenv(t, "VADS_ASR_HTTP_URL", "http://127.0.0.1:8080/recognize")
+			for k, v := range tt.env {
+				setenv(t, k, v)
+			}
+			_, err := Load(writeFile(t, "config.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := Load("/nonexistent/config.yaml"); err == nil {
+		t.Errorf("Load(nonexistent) error = nil, want error")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+		check func(c *Config) bool
+		ok    bool
+	}{
+		{"VADS_SERVER_LISTEN", "0.0.0.0:7000", func(c *Config) bool { return c.Server.Listen == "0.0.0.0:7000" }, true},
+		{"VADS_SERVER_CHUNK_TIMEOUT", "5s", func(c *Config) bool { return c.Server.ChunkTimeout == 5*time.Second }, true},
+		{"VADS_SERVER_MAX_SESSIONS", "100", func(c *Config) bool { return c.Server.MaxSessions == 100 }, true},
+		{"VADS_SERVER_LEGACY_COMPATIBLE", "false", func(c *Config) bool { return !c.Server.LegacyCompatible }, true},
+		{"VADS_ASR_ALIYUN_APP_KEY", "key", func(c *Config) bool { return c.ASR.Aliyun.AppKey == "key" }, true},
+		// the maps and slices are set in the yaml file only
+		{"VADS_SERVER_CHANNEL_MAX_SESSIONS", "5", func(c *Config) bool { return c.Server.ChannelMaxSessions == nil }, true},
+		{"VADS_PROFILES", "yesno", func(c *Config) bool { return c.Profiles == nil }, true},
+		{"VADS_SERVER_MAX_SESSIONS", "many", nil, false},
+		{"VADS_SERVER_CHUNK_TIMEOUT", "5", nil, false},
+		{"VADS_SERVER_LEGACY_COMPATIBLE", "maybe", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			setenv(t, tt.env, tt.value)
+			c := Default()
+			err := c.LoadEnv()
+			if (err == nil) != tt.ok {
+				t.Fatalf("LoadEnv() error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !tt.check(c) {
+				t.Errorf("LoadEnv() doesn't override the field by %v", tt.env)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	five := 5
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"empty listen", func(c *Config) { c.Server.Listen = "" }, "server.listen"},
+		{"admin on loopback", func(c *Config) { c.Server.AdminListen = "127.0.0.1:6001" }, ""},
+		{"admin on localhost", func(c *Config) { c.Server.AdminListen = "localhost:6001" }, ""},
+		{"admin disabled", func(c *Config) { c.Server.AdminListen = "" }, ""},
+		{"admin without token", func(c *Config) { c.Server.AdminListen = "0.0.0.0:6001" }, "server.admin_token"},
+		{"admin with token", func(c *Config) {
+			c.Server.AdminListen = "0.0.0.0:6001"
+			c.Server.AdminToken = "secret"
+		}, ""},
+		{"admin with missing token file", func(c *Config) {
+			c.Server.AdminListen = "0.0.0.0:6001"
+			c.Server.AdminTokenFile = "/nonexistent/token"
+		}, "server.admin_token_file"},
+		{"illegal admin listen", func(c *Config) { c.Server.AdminListen = "6001" }, "server.admin_listen"},
+		{"zero request timeout", func(c *Config) { c.Server.RequestTimeout = 0 }, "server.request_timeout"},
+		{"negative max sessions", func(c *Config) { c.Server.MaxSessions = -1 }, "server.max_sessions"},
+		{"negative channel max sessions", func(c *Config) { c.Server.ChannelMaxSessions = map[string]int{"03": -1} }, "server.channel_max_sessions.03"},
+		{"illegal vad level", func(c *Config) { c.VAD.VADLevel = 4 }, "vad"},
+		{"illegal engine", func(c *Config) { c.ASR.Engine = "foo" }, "asr.engine"},
+		{"aliyun without credentials", func(c *Config) { c.ASR.Engine = EngineAliyun }, "asr.aliyun.app_key"},
+		{"http without url", func(c *Config) { c.ASR.HTTP.URL = "" }, "asr.http.url"},
+		{"illegal log level", func(c *Config) { c.Log.Level = "verbose" }, "log"},
+		{"profile", func(c *Config) {
+			c.Profiles = map[string]*Profile{"yesno": {VAD: VADOverride{SilenceTimeout: &five}}}
+			c.Rules = []Rule{{Profile: "yesno", Channel: "03"}}
+		}, ""},
+		{"empty profile", func(c *Config) { c.Profiles = map[string]*Profile{"yesno": nil} }, "profiles.yesno"},
+		{"undefined profile in rule", func(c *Config) { c.Rules = []Rule{{Profile: "yesno"}} }, "rules[0].profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
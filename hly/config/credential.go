@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialFile is the cached content of a credential file
+type credentialFile struct {
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+var (
+	credentialMutex sync.Mutex
+	credentialFiles = make(map[string]*credentialFile)
+)
+
+// Credential returns the credential in the yaml path, which is the value set in the yaml file
+// or by the env var, or the content of the file if the value is empty, e.g. a secret mounted
+// by kubernetes or docker. The file is read again once it is modified, so that the rotated
+// credential takes effect without restart.
+func Credential(path, value, file string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if file == "" {
+		return "", fmt.Errorf("config error - %v is not configured, set it, %v or %v_file", path, EnvName(path), path)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", fmt.Errorf("config error - fail to read %v_file, error: %v", path, err)
+	}
+
+	credentialMutex.Lock()
+	defer credentialMutex.Unlock()
+	if f, ok := credentialFiles[file]; ok && f.modTime.Equal(fi.ModTime()) && f.size == fi.Size() {
+		return f.value, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("config error - fail to read %v_file, error: %v", path, err)
+	}
+	value = strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("config error - %v_file %v is empty", path, file)
+	}
+	credentialFiles[file] = &credentialFile{modTime: fi.ModTime(), size: fi.Size(), value: value}
+	return value, nil
+}
+
+// AliyunCredentials are the credentials of the aliyun speech recognition service
+type AliyunCredentials struct {
+	AppKey          string
+	AccessKeyID     string
+	AccessKeySecret string
+}
+
+// Credentials returns the credentials of the aliyun service, which are read from the
+// credential files unless they are set
+func (a *Aliyun) Credentials() (*AliyunCredentials, error) {
+	var c AliyunCredentials
+	var err error
+	if c.AppKey, err = Credential("asr.aliyun.app_key", a.AppKey, a.AppKeyFile); err != nil {
+		return nil, err
+	}
+	if c.AccessKeyID, err = Credential("asr.aliyun.access_key_id", a.AccessKeyID, a.AccessKeyIDFile); err != nil {
+		return nil, err
+	}
+	if c.AccessKeySecret, err = Credential("asr.aliyun.access_key_secret", a.AccessKeySecret, a.AccessKeySecretFile); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the env vars overriding the configuration
+const EnvPrefix = "VADS"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadEnv overrides the scalar fields of the configuration with the env vars, and the maps
+// and slices are not overridden
+func (c *Config) LoadEnv() error {
+	return walk(reflect.ValueOf(c).Elem(), "", false, func(path string, f reflect.Value) error {
+		name := EnvName(path)
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		if err := setValue(f, s); err != nil {
+			return fmt.Errorf("config error - illegal env %v for %v, error: %v", name, path, err)
+		}
+		return nil
+	})
+}
+
+// EnvName returns the name of the env var overriding the field in the yaml path, e.g.
+// VADS_SERVER_LISTEN for server.listen
+func EnvName(path string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.Replace(path, ".", "_", -1))
+}
+
+// walk walks the leaf fields of the value by the yaml tags with their yaml paths. The entries of
+// maps and slices are walked only if deep is true, e.g. profiles.yesno.asr and rules[0].profile,
+// and a nil pointer is a leaf.
+func walk(v reflect.Value, path string, deep bool, fn func(path string, f reflect.Value) error) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			p := tag
+			if path != "" {
+				p = path + "." + tag
+			}
+			if err := walk(v.Field(i), p, deep, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if !deep {
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			if err := walk(v.MapIndex(k), path+"."+k.String(), deep, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if !deep {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := walk(v.Index(i), fmt.Sprintf("%v[%d]", path, i), deep, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return fn(path, v)
+		}
+		return walk(v.Elem(), path, deep, fn)
+	default:
+		return fn(path, v)
+	}
+	return nil
+}
+
+func setValue(f reflect.Value, s string) error {
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %v", f.Type())
+	}
+	return nil
+}
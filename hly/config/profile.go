@@ -0,0 +1,234 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/henryleu/go-vad"
+)
+
+const (
+	// EngineAliyun is the aliyun speech recognition service
+	EngineAliyun = "aliyun"
+
+	// EngineAicyber is the aicyber speech recognition service
+	EngineAicyber = "aicyber"
+
+	// EngineHTTP is the generic http speech recognition service
+	EngineHTTP = "http"
+)
+
+// engines are the names of the supported speech recognition services, including the ones
+// registered by RegisterEngine
+var (
+	enginesMutex sync.RWMutex
+	engines      = []string{EngineAliyun, EngineAicyber, EngineHTTP}
+)
+
+// RegisterEngine adds the name of a speech recognition service, so that it can be used as
+// asr.engine and the asr of profiles. It is called by asr.Register.
+func RegisterEngine(name string) {
+	enginesMutex.Lock()
+	defer enginesMutex.Unlock()
+	for _, e := range engines {
+		if e == name {
+			return
+		}
+	}
+	engines = append(engines, name)
+}
+
+// EngineNames returns the names of the supported speech recognition services
+func EngineNames() []string {
+	enginesMutex.RLock()
+	defer enginesMutex.RUnlock()
+	return append([]string(nil), engines...)
+}
+
+// Profile is a named set of the vad params and the asr engine for a tenant, e.g.
+//
+//	profiles:
+//	  yesno:
+//	    vad:
+//	      silence_timeout: 300
+//	    asr: aicyber
+type Profile struct {
+	// VAD overrides the vad params of the vad section, and the params not specified are left as it is
+	VAD VADOverride `yaml:"vad"`
+
+	// ASR is the asr engine, which is asr.engine if empty
+	ASR string `yaml:"asr"`
+}
+
+// VADOverride is the vad params overriding the default ones, and the nil params are not overridden
+type VADOverride struct {
+	SpeechTimeout      *int `yaml:"speech_timeout"`
+	SilenceTimeout     *int `yaml:"silence_timeout"`
+	NoinputTimeout     *int `yaml:"noinput_timeout"`
+	RecognitionTimeout *int `yaml:"recognition_timeout"`
+	VADLevel           *int `yaml:"vad_level"`
+}
+
+// Apply overrides the detector config with the specified vad params
+func (o *VADOverride) Apply(vc *vad.Config) {
+	if o.SpeechTimeout != nil {
+		vc.SpeechTimeout = *o.SpeechTimeout
+	}
+	if o.SilenceTimeout != nil {
+		vc.SilenceTimeout = *o.SilenceTimeout
+	}
+	if o.NoinputTimeout != nil {
+		vc.NoinputTimeout = *o.NoinputTimeout
+	}
+	if o.RecognitionTimeout != nil {
+		vc.RecognitionTimeout = *o.RecognitionTimeout
+	}
+	if o.VADLevel != nil {
+		vc.VADLevel = vad.Level(*o.VADLevel)
+	}
+}
+
+// Rule selects the profile for the session by the business info of the request.
+// All the criteria specified should be matched, and the empty ones match anything, e.g.
+//
+//	rules:
+//	  - profile: yesno
+//	    channel: "03"
+//	    called_prefix: "400"
+type Rule struct {
+	// Profile is the name of the profile selected
+	Profile string `yaml:"profile"`
+
+	Channel      string `yaml:"channel"`
+	Province     string `yaml:"province"`
+	CalledPrefix string `yaml:"called_prefix"`
+}
+
+// Match checks if the business info matches the rule
+func (r *Rule) Match(channel, province, called string) bool {
+	if r.Channel != "" && r.Channel != channel {
+		return false
+	}
+	if r.Province != "" && r.Province != province {
+		return false
+	}
+	if r.CalledPrefix != "" && !strings.HasPrefix(called, r.CalledPrefix) {
+		return false
+	}
+	return true
+}
+
+// SelectProfile returns the name of the profile selected by the first rule matched,
+// or empty if no rule is matched.
+func (c *Config) SelectProfile(channel, province, called string) string {
+	for i := range c.Rules {
+		if c.Rules[i].Match(channel, province, called) {
+			return c.Rules[i].Profile
+		}
+	}
+	return ""
+}
+
+// Profile returns the profile by name
+func (c *Config) Profile(name string) (*Profile, bool) {
+	p, ok := c.Profiles[name]
+	return p, ok
+}
+
+// Engine returns the asr engine of the profile, or the default one if the profile is nil
+func (c *Config) Engine(p *Profile) string {
+	if p != nil && p.ASR != "" {
+		return p.ASR
+	}
+	return c.ASR.Engine
+}
+
+// Engines returns the asr engines in use by default and by the profiles, in the order of engines
+func (c *Config) Engines() []string {
+	used := map[string]bool{c.ASR.Engine: true}
+	for _, p := range c.Profiles {
+		if p != nil && p.ASR != "" {
+			used[p.ASR] = true
+		}
+	}
+	var names []string
+	for _, e := range EngineNames() {
+		if used[e] {
+			names = append(names, e)
+		}
+	}
+	return names
+}
+
+// validateProfiles checks if the profiles and the rules are valid
+func (c *Config) validateProfiles() error {
+	if !isEngine(c.ASR.Engine) {
+		return fmt.Errorf("config error - asr.engine should be one of %v, got %q", EngineNames(), c.ASR.Engine)
+	}
+	for name, p := range c.Profiles {
+		if p == nil {
+			return fmt.Errorf("config error - profiles.%v should not be empty", name)
+		}
+		if p.ASR != "" && !isEngine(p.ASR) {
+			return fmt.Errorf("config error - profiles.%v.asr should be one of %v, got %q", name, EngineNames(), p.ASR)
+		}
+		vc := vad.NewDefaultConfig()
+		c.VAD.Apply(vc)
+		p.VAD.Apply(vc)
+		if err := vc.Validate(); err != nil {
+			return fmt.Errorf("config error - profiles.%v.vad is invalid, error: %v", name, err)
+		}
+	}
+	for i, r := range c.Rules {
+		if _, ok := c.Profiles[r.Profile]; !ok {
+			return fmt.Errorf("config error - rules[%d].profile %q is not defined in profiles", i, r.Profile)
+		}
+	}
+	return nil
+}
+
+// validateEngines checks if the required fields and the credentials of the built-in asr engines
+// in use are set
+func (c *Config) validateEngines() error {
+	for _, e := range c.Engines() {
+		var required []struct{ name, value string }
+		switch e {
+		case EngineAliyun:
+			required = []struct{ name, value string }{
+				{"asr.aliyun.url", c.ASR.Aliyun.URL},
+				{"asr.aliyun.region", c.ASR.Aliyun.Region},
+				{"asr.aliyun.token_domain", c.ASR.Aliyun.TokenDomain},
+			}
+			// the server refuses to start without the credentials
+			if _, err := c.ASR.Aliyun.Credentials(); err != nil {
+				return fmt.Errorf("%v, which is required since aliyun is in use", err)
+			}
+		case EngineAicyber:
+			required = []struct{ name, value string }{
+				{"asr.aicyber.url", c.ASR.Aicyber.URL},
+			}
+		case EngineHTTP:
+			required = []struct{ name, value string }{
+				{"asr.http.url", c.ASR.HTTP.URL},
+				{"asr.http.content_type", c.ASR.HTTP.ContentType},
+				{"asr.http.text_field", c.ASR.HTTP.TextField},
+			}
+		}
+		for _, f := range required {
+			if f.value == "" {
+				return fmt.Errorf("config error - %v should not be empty since %v is in use", f.name, e)
+			}
+		}
+	}
+	return nil
+}
+
+func isEngine(name string) bool {
+	for _, e := range EngineNames() {
+		if name == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/henryleu/vads/hly/logging"
+)
+
+// restartPaths are the fields which take effect only after the server restarts
+var restartPaths = map[string]bool{
+	"server.listen":       true,
+	"server.tcp_listen":   true,
+	"server.admin_listen": true,
+}
+
+// secretSuffixes are the suffixes of the fields whose values are never logged
+var secretSuffixes = []string{"key", "key_id", "key_secret", "secret", "token", "password"}
+
+// reloadMutex serializes the reloading by the signal and the admin endpoint
+var reloadMutex sync.Mutex
+
+// Reload loads the configuration from the yaml file again and sets it in use if it is valid,
+// otherwise the configuration in use is kept. The new configuration takes effect on the new
+// sessions only, except the logging which takes effect at once. It returns the changes, which
+// are logged too.
+func Reload(path string) ([]string, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	logger := logging.Phase(logging.PhaseConfig)
+	c, err := Load(path)
+	if err != nil {
+		logger.Errorf("fail to reload config from %q, the config in use is kept, error: %v", path, err)
+		return nil, err
+	}
+	changes := Diff(Current(), c)
+	Set(c)
+	c.Log.Apply()
+	if len(changes) == 0 {
+		logger.Infof("config is reloaded from %q without changes", path)
+		return changes, nil
+	}
+	logger.Infof("config is reloaded from %q with %v changes, which take effect on new sessions", path, len(changes))
+	for _, change := range changes {
+		logger.Infof("config changed: %v", change)
+	}
+	return changes, nil
+}
+
+// Diff returns the changes from one configuration to another, e.g.
+// "vad.vad_level: 2 -> 3". The values of the secret fields are masked.
+func Diff(from, to *Config) []string {
+	values := make(map[string]interface{})
+	var paths []string
+	walk(reflect.ValueOf(from).Elem(), "", true, func(path string, f reflect.Value) error {
+		values[path] = leaf(f)
+		paths = append(paths, path)
+		return nil
+	})
+	var changes []string
+	walk(reflect.ValueOf(to).Elem(), "", true, func(path string, f reflect.Value) error {
+		o, ok := values[path]
+		delete(values, path)
+		n := leaf(f)
+		if ok && o == n || !ok && n == nil {
+			return nil
+		}
+		if !ok {
+			o = none{}
+		}
+		changes = append(changes, change(path, o, n))
+		return nil
+	})
+	// the fields of the removed profiles and rules
+	for _, path := range paths {
+		if o, ok := values[path]; ok && o != nil {
+			changes = append(changes, change(path, o, none{}))
+		}
+	}
+	return changes
+}
+
+// none is the value of the field which doesn't exist
+type none struct{}
+
+func (none) String() string {
+	return "<none>"
+}
+
+// leaf returns the value of the leaf field, or nil if it is a nil pointer
+func leaf(f reflect.Value) interface{} {
+	if f.Kind() == reflect.Ptr {
+		return nil
+	}
+	return f.Interface()
+}
+
+func change(path string, from, to interface{}) string {
+	var c string
+	if isSecret(path) {
+		c = fmt.Sprintf("%v: changed", path)
+	} else {
+		c = fmt.Sprintf("%v: %v -> %v", path, format(from), format(to))
+	}
+	if restartPaths[path] {
+		c += " (takes effect after restart)"
+	}
+	return c
+}
+
+func isSecret(path string) bool {
+	// the headers of the http asr service may carry the credentials, e.g. Authorization
+	if strings.HasPrefix(path, "asr.http.headers.") {
+		return true
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(path, "_"+suffix) || strings.HasSuffix(path, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func format(v interface{}) string {
+	if v == nil {
+		// the nil vad params in profiles are left as default
+		return "<default>"
+	}
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
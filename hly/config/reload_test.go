@@ -0,0 +1,88 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	three, five := 3, 5
+	tests := []struct {
+		name   string
+		from   func(c *Config)
+		to     func(c *Config)
+		change []string
+	}{
+		{"no change", func(c *Config) {}, func(c *Config) {}, nil},
+		{"scalars", func(c *Config) {}, func(c *Config) {
+			c.VAD.VADLevel = 3
+			c.ASR.HTTP.URL = "http://127.0.0.1:8081/recognize"
+		}, []string{
+			"vad.vad_level: 2 -> 3",
+			`asr.http.url: "http://127.0.0.1:8080/recognize" -> "http://127.0.0.1:8081/recognize"`,
+		}},
+		{"restart", func(c *Config) {}, func(c *Config) { c.Server.Listen = "0.0.0.0:7000" }, []string{
+			`server.listen: "0.0.0.0:6000" -> "0.0.0.0:7000" (takes effect after restart)`,
+		}},
+		{"added profile", func(c *Config) {}, func(c *Config) {
+			c.Profiles = map[string]*Profile{"yesno": {VAD: VADOverride{SilenceTimeout: &five}, ASR: EngineAicyber}}
+		}, []string{
+			"profiles.yesno.vad.silence_timeout: <none> -> 5",
+			`profiles.yesno.asr: <none> -> "aicyber"`,
+		}},
+		{"removed profile", func(c *Config) {
+			c.Profiles = map[string]*Profile{"yesno": {VAD: VADOverride{SilenceTimeout: &five}}}
+		}, func(c *Config) {}, []string{
+			"profiles.yesno.vad.silence_timeout: 5 -> <none>",
+			`profiles.yesno.asr: "" -> <none>`,
+		}},
+		{"added rule", func(c *Config) {}, func(c *Config) {
+			c.Rules = []Rule{{Profile: "yesno", Channel: "03"}}
+		}, []string{
+			`rules[0].profile: <none> -> "yesno"`,
+			`rules[0].channel: <none> -> "03"`,
+			`rules[0].province: <none> -> ""`,
+			`rules[0].called_prefix: <none> -> ""`,
+		}},
+		{"overridden", func(c *Config) {
+			c.Profiles = map[string]*Profile{"yesno": {}}
+		}, func(c *Config) {
+			c.Profiles = map[string]*Profile{"yesno": {VAD: VADOverride{VADLevel: &three}}}
+		}, []string{
+			"profiles.yesno.vad.vad_level: <default> -> 3",
+		}},
+		{"not overridden", func(c *Config) {
+			c.Profiles = map[string]*Profile{"yesno": {VAD: VADOverride{VADLevel: &three}}}
+		}, func(c *Config) {
+			c.Profiles = map[string]*Profile{"yesno": {}}
+		}, []string{
+			"profiles.yesno.vad.vad_level: 3 -> <default>",
+		}},
+		{"secrets", func(c *Config) {
+			c.ASR.HTTP.Headers = map[string]string{"Authorization": "Bearer old"}
+		}, func(c *Config) {
+			c.Server.AdminToken = "secret"
+			c.ASR.Aliyun.AccessKeySecret = "secret"
+			c.ASR.HTTP.Headers = map[string]string{"Authorization": "Bearer new"}
+		}, []string{
+			"server.admin_token: changed",
+			"asr.aliyun.access_key_secret: changed",
+			"asr.http.headers.Authorization: changed",
+		}},
+		{"removed secret", func(c *Config) {
+			c.ASR.HTTP.Headers = map[string]string{"Authorization": "Bearer old"}
+		}, func(c *Config) {}, []string{
+			"asr.http.headers.Authorization: changed",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := testConfig(), testConfig()
+			tt.from(from)
+			tt.to(to)
+			if got := Diff(from, to); !reflect.DeepEqual(got, tt.change) {
+				t.Errorf("Diff() = %q, want %q", got, tt.change)
+			}
+		})
+	}
+}
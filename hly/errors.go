@@ -0,0 +1,122 @@
+package hly
+
+import (
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	错误码
+	失败应答的result中带error（错误名称）和error_code（错误编号），websocket连接以对应的关闭码关闭，关闭原因为错误名称。
+	客户端应按error_code或error区分处理，detail仅为描述，内容不保证稳定。
+
+	error_code	error						关闭码		说明
+	----------------------------------------
+	1						bad_request			4001			请求或消息非法，如字段非法、cid不一致、方案不存在、传输方式不符
+	2						timeout					4002			服务器在request_timeout内未收到请求
+	3						chunk_sequence	4003			分片编号超前过多（50个以上）
+	4						decode_error		4004			消息或音频无法解码，如json非法、base64非法
+	5						noinput					4005			无输入超时，没有检测到语音
+	6						asr_failure			4006			语音识别失败
+	7						flow_failure		4007			对话流程服务调用失败
+	8						overload				1013			服务繁忙（并发会话数超限），code为2，可换其它服务器重试
+	9						internal				1011			服务器内部错误，如语音保存失败、检测器初始化失败
+	10					unavailable			1001			服务器正在关闭
+	11					terminated			1008			会话被管理接口终止
+
+	备注：取消会话的应答（detail为cancelled）及成功应答不带error和error_code；
+	请求到达前的错误（如timeout、decode_error）没有应答，仅以关闭码关闭连接
+*/
+
+// ErrorCode is the stable code of the failed session in the error catalog, which is sent
+// in the result of the response with its name, and decides the close code of the connection
+type ErrorCode int
+
+const (
+	// ErrBadRequest is the illegal request or message, e.g. illegal fields, mismatched cid or unknown profile
+	ErrBadRequest ErrorCode = iota + 1
+
+	// ErrTimeout is the request not received within server.request_timeout
+	ErrTimeout
+
+	// ErrChunkSequence is the chunk whose no is too far ahead of the expected one
+	ErrChunkSequence
+
+	// ErrDecode is the message or the audio which can't be decoded
+	ErrDecode
+
+	// ErrNoinput is no speech detected until the noinput timeout
+	ErrNoinput
+
+	// ErrASR is the failed speech recognition
+	ErrASR
+
+	// ErrFlow is the failed call to the dialog flow service
+	ErrFlow
+
+	// ErrOverload is the session rejected since the server or the channel is busy
+	ErrOverload
+
+	// ErrInternal is the failure of the server, e.g. the clip can't be saved
+	ErrInternal
+
+	// ErrUnavailable is the session stopped or rejected since the server is shutting down
+	ErrUnavailable
+
+	// ErrTerminated is the session terminated by the admin api
+	ErrTerminated
+)
+
+// errorEntry is the name and the close code of an error code
+type errorEntry struct {
+	name      string
+	closeCode int
+}
+
+// errorCatalog is the catalog of the error codes
+var errorCatalog = map[ErrorCode]errorEntry{
+	ErrBadRequest:    {"bad_request", 4001},
+	ErrTimeout:       {"timeout", 4002},
+	ErrChunkSequence: {"chunk_sequence", 4003},
+	ErrDecode:        {"decode_error", 4004},
+	ErrNoinput:       {"noinput", 4005},
+	ErrASR:           {"asr_failure", 4006},
+	ErrFlow:          {"flow_failure", 4007},
+	ErrOverload:      {"overload", websocket.CloseTryAgainLater},
+	ErrInternal:      {"internal", websocket.CloseInternalServerErr},
+	ErrUnavailable:   {"unavailable", websocket.CloseGoingAway},
+	ErrTerminated:    {"terminated", websocket.ClosePolicyViolation},
+}
+
+// String returns the name of the error code, e.g. decode_error
+func (c ErrorCode) String() string {
+	if e, ok := errorCatalog[c]; ok {
+		return e.name
+	}
+	return fmt.Sprintf("error_%d", int(c))
+}
+
+// CloseCode returns the close code of the connection for the error code
+func (c ErrorCode) CloseCode() int {
+	if e, ok := errorCatalog[c]; ok {
+		return e.closeCode
+	}
+	return websocket.CloseInternalServerErr
+}
+
+// Error is the error of the session with its code in the catalog and the detail for human
+type Error struct {
+	Code   ErrorCode
+	Detail string
+}
+
+// newError creates an error with the code and the detail formatted
+func newError(code ErrorCode, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Detail: fmt.Sprintf(format, a...)}
+}
+
+// Error returns the name of the code and the detail
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Detail)
+}
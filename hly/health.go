@@ -0,0 +1,222 @@
+package hly
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/henryleu/vads/hly/config"
+)
+
+// Check is the result of a readiness check
+type Check struct {
+	// Name is the name of the check, e.g. voice_dir, vad or asr.aliyun.url
+	Name string `json:"name"`
+
+	OK bool `json:"ok"`
+
+	// Error is the reason of the failed check
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness is the result of the readiness checks
+type Readiness struct {
+	Ready  bool     `json:"ready"`
+	Checks []*Check `json:"checks"`
+}
+
+// probeResult is the cached result of a service probe
+type probeResult struct {
+	err  error
+	time time.Time
+}
+
+// probeCache caches the results of the service probes, so that the frequent readiness
+// checks of the load balancer don't flood the services
+type probeCache struct {
+	mutex   sync.Mutex
+	results map[string]*probeResult
+}
+
+func newProbeCache() *probeCache {
+	return &probeCache{results: make(map[string]*probeResult)}
+}
+
+// probe connects to the hosts of the urls concurrently unless their results are cached
+// within the interval, and returns the errors by url
+func (c *probeCache) probe(urls []string, timeout, interval time.Duration) map[string]error {
+	errs := make(map[string]error)
+	var stale []string
+	c.mutex.Lock()
+	for _, u := range urls {
+		r, ok := c.results[u]
+		if ok && time.Since(r.time) < interval {
+			errs[u] = r.err
+			continue
+		}
+		stale = append(stale, u)
+	}
+	c.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	results := make([]*probeResult, len(stale))
+	for i, u := range stale {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			results[i] = &probeResult{err: dial(u, timeout), time: time.Now()}
+		}(i, u)
+	}
+	wg.Wait()
+
+	c.mutex.Lock()
+	for i, u := range stale {
+		c.results[u] = results[i]
+		errs[u] = results[i].err
+	}
+	c.mutex.Unlock()
+	return errs
+}
+
+// dial connects to the host of the url to check if the service is reachable
+func dial(rawurl string, timeout time.Duration) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("no host in url %q", rawurl)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Ready checks if the server is ready to serve sessions with the config: the server is not
+// shutting down, the voice dir is writable, the detector can be initialized, the credentials
+// of the asr services in use can be read, and the asr services in use are reachable.
+// The services out of the recognition, e.g. the flow service, are not checked.
+// The probe results of the services are cached for health.probe_interval.
+func (srv *Server) Ready(cfg *config.Config) *Readiness {
+	rd := &Readiness{Ready: true}
+	add := func(name string, err error) {
+		c := &Check{Name: name, OK: err == nil}
+		if err != nil {
+			c.Error = err.Error()
+			rd.Ready = false
+		}
+		rd.Checks = append(rd.Checks, c)
+	}
+
+	srv.mutex.Lock()
+	shuttingDown := srv.shuttingDown
+	srv.mutex.Unlock()
+	if shuttingDown {
+		add("server", errors.New(ShuttingDownReason))
+	}
+	add("voice_dir", checkVoiceDir(cfg))
+	add("vad", checkVAD(cfg))
+
+	type service struct{ name, url string }
+	var services []service
+	for _, e := range cfg.Engines() {
+		switch e {
+		case config.EngineAliyun:
+			// the credential files may be removed or emptied by the rotation
+			_, err := cfg.ASR.Aliyun.Credentials()
+			add("asr.aliyun.credentials", err)
+			services = append(services,
+				service{"asr.aliyun.url", cfg.ASR.Aliyun.URL},
+				service{"asr.aliyun.token_domain", "http://" + cfg.ASR.Aliyun.TokenDomain})
+		case config.EngineAicyber:
+			services = append(services, service{"asr.aicyber.url", cfg.ASR.Aicyber.URL})
+		case config.EngineHTTP:
+			services = append(services, service{"asr.http.url", cfg.ASR.HTTP.URL})
+		}
+	}
+	urls := make([]string, len(services))
+	for i, s := range services {
+		urls[i] = s.url
+	}
+	errs := srv.probes.probe(urls, cfg.Health.ProbeTimeout, cfg.Health.ProbeInterval)
+	for _, s := range services {
+		add(s.name, errs[s.url])
+	}
+	return rd
+}
+
+// checkVoiceDir checks if the clip files can be saved in the voice dir
+func checkVoiceDir(cfg *config.Config) error {
+	dir, err := getVoiceDir(cfg)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkVAD checks if the detector can be initialized with the vad params
+func checkVAD(cfg *config.Config) error {
+	vc, err := getConfig(cfg)
+	if err != nil {
+		return err
+	}
+	vc.SampleRate = DefaultRate
+	d := vc.NewDetector()
+	d.SampleRate = DefaultRate
+	d.BytesPerSample = bytesPerSample
+	d.FrameDuration = frameDuration
+	return d.Init()
+}
+
+// HealthzHandler returns the handler which reports the process is alive, e.g. GET /healthz
+func HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// ReadyzHandler returns the handler which reports if the server is ready with the config
+// in use, e.g. GET /readyz. It responds 200 if ready, otherwise 503, with the checks.
+// The errors of the failed checks are left out, since they may reveal the credential files
+// and the internal urls on the public listener, and they are reported by GET /admin/readyz.
+func (srv *Server) ReadyzHandler() http.HandlerFunc {
+	return srv.readyzHandler(false)
+}
+
+// readyzHandler returns the handler of the readiness checks, which reports the errors
+// of the failed checks if detail is true
+func (srv *Server) readyzHandler(detail bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rd := srv.Ready(config.Current())
+		status := http.StatusOK
+		if !rd.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		if !detail {
+			for _, c := range rd.Checks {
+				c.Error = ""
+			}
+		}
+		writeJSON(w, status, rd)
+	}
+}
@@ -0,0 +1,68 @@
+package hly
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/henryleu/vads/hly/config"
+)
+
+// closedURL returns the url of a port which refuses the connections
+func closedURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return "http://" + addr + "/asr"
+}
+
+func TestReadyz(t *testing.T) {
+	cfg := testConfig(t, closedURL(t))
+	cfg.Flow.SayURL = closedURL(t)
+	config.Set(cfg)
+	defer config.Set(config.Default())
+
+	srv := NewServer()
+	tests := []struct {
+		name    string
+		handler http.Handler
+		detail  bool
+	}{
+		{"public", srv.ReadyzHandler(), false},
+		{"admin", srv.AdminHandler(""), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/readyz", nil))
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+			}
+			var rd Readiness
+			if err := json.Unmarshal(w.Body.Bytes(), &rd); err != nil {
+				t.Fatal(err)
+			}
+			failed := map[string]string{}
+			for _, c := range rd.Checks {
+				if c.Name == "flow.say_url" {
+					t.Errorf("check %v, want no check of the flow service", c.Name)
+				}
+				if !c.OK {
+					failed[c.Name] = c.Error
+				}
+			}
+			msg, ok := failed["asr.http.url"]
+			if !ok || len(failed) != 1 {
+				t.Fatalf("failed checks = %v, want asr.http.url only", failed)
+			}
+			if (msg != "") != tt.detail {
+				t.Errorf("error of asr.http.url = %q, want detail %v", msg, tt.detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package hly
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/henryleu/vads/hly/metrics"
+)
+
+// chunkStats counts the chunks which are not received in order during a session.
+// It is updated by the chunk processing and read by the event handling concurrently.
+type chunkStats struct {
+	// reordered is the number of chunks received after the following ones
+	reordered int64
+
+	// lost is the number of chunks never received in the window and filled with silence
+	lost int64
+
+	// dropped is the number of chunks received too late or duplicated
+	dropped int64
+}
+
+// Reordered returns the number of chunks received out of order
+func (s *chunkStats) Reordered() int {
+	return int(atomic.LoadInt64(&s.reordered))
+}
+
+// Lost returns the number of chunks filled with silence
+func (s *chunkStats) Lost() int {
+	return int(atomic.LoadInt64(&s.lost))
+}
+
+// Dropped returns the number of chunks dropped
+func (s *chunkStats) Dropped() int {
+	return int(atomic.LoadInt64(&s.dropped))
+}
+
+// String returns the stats for the response detail, or empty if all the chunks are in order
+func (s *chunkStats) String() string {
+	var items []string
+	if n := s.Reordered(); n > 0 {
+		items = append(items, fmt.Sprintf("%d chunks reordered", n))
+	}
+	if n := s.Lost(); n > 0 {
+		items = append(items, fmt.Sprintf("%d chunks lost", n))
+	}
+	if n := s.Dropped(); n > 0 {
+		items = append(items, fmt.Sprintf("%d chunks dropped", n))
+	}
+	return strings.Join(items, ", ")
+}
+
+// jitterBuffer reorders the chunks by the chunk no in a small window. A chunk missing
+// when the window is full is filled with silence, so that a transient network issue
+// doesn't kill the session. The chunk data put into it should be linear pcm.
+type jitterBuffer struct {
+	// window is the max number of chunks waiting for a missing chunk
+	window int
+
+	// next is the no of the next chunk to be released
+	next int
+
+	// pending are the chunks received ahead of the next chunk, keyed by chunk no
+	pending map[int][]byte
+
+	// last is the no of the last pending chunk
+	last int
+
+	// size is the bytes of the last released chunk, which is the size of the silence filled
+	// unless no chunk is released yet
+	size int
+
+	stats chunkStats
+}
+
+func newJitterBuffer(window int) *jitterBuffer {
+	return &jitterBuffer{
+		window:  window,
+		next:    1,
+		pending: make(map[int][]byte),
+	}
+}
+
+// Next returns the no of the next chunk expected
+func (j *jitterBuffer) Next() int {
+	return j.next
+}
+
+// Put puts the chunk data by its no and returns the chunks released in order
+func (j *jitterBuffer) Put(no int, data []byte) [][]byte {
+	if no < j.next {
+		atomic.AddInt64(&j.stats.dropped, 1)
+		metrics.ChunksJitter.WithLabelValues("dropped").Inc()
+		return nil
+	}
+	if _, ok := j.pending[no]; ok {
+		atomic.AddInt64(&j.stats.dropped, 1)
+		metrics.ChunksJitter.WithLabelValues("dropped").Inc()
+		return nil
+	}
+	if no < j.last {
+		atomic.AddInt64(&j.stats.reordered, 1)
+		metrics.ChunksJitter.WithLabelValues("reordered").Inc()
+	}
+	j.pending[no] = data
+	if no > j.last {
+		j.last = no
+	}
+	return j.release(false)
+}
+
+// Flush returns all the pending chunks in order with the missing ones filled with silence
+func (j *jitterBuffer) Flush() [][]byte {
+	return j.release(true)
+}
+
+// silenceSize returns the size of the silence filled for a missing chunk, which is the size of
+// the last released chunk, or the size of the lowest pending chunk if none is released yet
+func (j *jitterBuffer) silenceSize() int {
+	if j.size > 0 {
+		return j.size
+	}
+	lowest := 0
+	for no := range j.pending {
+		if lowest == 0 || no < lowest {
+			lowest = no
+		}
+	}
+	return len(j.pending[lowest])
+}
+
+// release releases the chunks in order. The missing chunk is filled with silence
+// if the pending chunks exceed the window, or all is true.
+func (j *jitterBuffer) release(all bool) [][]byte {
+	var chunks [][]byte
+	for len(j.pending) > 0 {
+		data, ok := j.pending[j.next]
+		if ok {
+			delete(j.pending, j.next)
+			j.size = len(data)
+		} else {
+			if !all && j.last-j.next < j.window {
+				break
+			}
+			data = make([]byte, j.silenceSize())
+			atomic.AddInt64(&j.stats.lost, 1)
+			metrics.ChunksJitter.WithLabelValues("lost").Inc()
+		}
+		chunks = append(chunks, data)
+		j.next++
+	}
+	return chunks
+}
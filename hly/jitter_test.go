@@ -0,0 +1,115 @@
+package hly
+
+import (
+	"bytes"
+	"testing"
+)
+
+// chunk returns the chunk data of the size filled with the no
+func chunk(no, size int) []byte {
+	return bytes.Repeat([]byte{byte(no)}, size)
+}
+
+func TestJitterBuffer(t *testing.T) {
+	type put struct {
+		no   int
+		size int
+	}
+	tests := []struct {
+		name      string
+		window    int
+		puts      []put
+		want      [][]byte
+		flush     [][]byte
+		reordered int
+		lost      int
+		dropped   int
+	}{
+		{
+			name:   "in order",
+			window: 2,
+			puts:   []put{{1, 4}, {2, 4}, {3, 4}},
+			want:   [][]byte{chunk(1, 4), chunk(2, 4), chunk(3, 4)},
+		},
+		{
+			name:      "reordered in the window",
+			window:    2,
+			puts:      []put{{1, 4}, {3, 4}, {2, 4}, {4, 4}},
+			want:      [][]byte{chunk(1, 4), chunk(2, 4), chunk(3, 4), chunk(4, 4)},
+			reordered: 1,
+		},
+		{
+			name:   "lost out of the window",
+			window: 2,
+			puts:   []put{{1, 4}, {3, 4}, {4, 4}},
+			want:   [][]byte{chunk(1, 4), make([]byte, 4), chunk(3, 4), chunk(4, 4)},
+			lost:   1,
+		},
+		{
+			name:   "first chunk lost",
+			window: 2,
+			puts:   []put{{2, 6}, {3, 6}},
+			want:   [][]byte{make([]byte, 6), chunk(2, 6), chunk(3, 6)},
+			lost:   1,
+		},
+		{
+			name:    "duplicated and late",
+			window:  2,
+			puts:    []put{{1, 4}, {1, 4}, {3, 4}, {3, 4}, {4, 4}, {2, 4}},
+			want:    [][]byte{chunk(1, 4), make([]byte, 4), chunk(3, 4), chunk(4, 4)},
+			lost:    1,
+			dropped: 3,
+		},
+		{
+			name:   "flushed",
+			window: 4,
+			puts:   []put{{1, 4}, {3, 4}, {5, 4}},
+			want:   [][]byte{chunk(1, 4)},
+			flush:  [][]byte{make([]byte, 4), chunk(3, 4), make([]byte, 4), chunk(5, 4)},
+			lost:   2,
+		},
+		{
+			name:   "flushed with first chunk lost",
+			window: 4,
+			puts:   []put{{3, 2}},
+			flush:  [][]byte{make([]byte, 2), make([]byte, 2), chunk(3, 2)},
+			lost:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := newJitterBuffer(tt.window)
+			var got [][]byte
+			for _, p := range tt.puts {
+				got = append(got, j.Put(p.no, chunk(p.no, p.size))...)
+			}
+			if !equalChunks(got, tt.want) {
+				t.Errorf("Put() released %v, want %v", got, tt.want)
+			}
+			if flush := j.Flush(); !equalChunks(flush, tt.flush) {
+				t.Errorf("Flush() = %v, want %v", flush, tt.flush)
+			}
+			if n := j.stats.Reordered(); n != tt.reordered {
+				t.Errorf("Reordered() = %v, want %v", n, tt.reordered)
+			}
+			if n := j.stats.Lost(); n != tt.lost {
+				t.Errorf("Lost() = %v, want %v", n, tt.lost)
+			}
+			if n := j.stats.Dropped(); n != tt.dropped {
+				t.Errorf("Dropped() = %v, want %v", n, tt.dropped)
+			}
+		})
+	}
+}
+
+func equalChunks(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+// Package logging provides the leveled structured logging of the vads server. Every line
+// of a session carries the cid, uid and channel of the session and the phase it is logged
+// in, so that a single call can be traced through the concurrent sessions, e.g.
+//
+//	{"channel":"03","cid":"01010101010","level":"info","msg":"session is ended by client after 38 chunks","phase":"stream","time":"...","uid":"u"}
+package logging
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the fields of the log lines
+const (
+	FieldCID     = "cid"
+	FieldUID     = "uid"
+	FieldChannel = "channel"
+	FieldPhase   = "phase"
+
+	// FieldRemote is the remote address of the connection
+	FieldRemote = "remote"
+)
+
+// the phases of the log lines
+const (
+	// PhaseConnect is for accepting or upgrading the connections
+	PhaseConnect = "connect"
+
+	// PhaseRequest is for receiving and validating the request
+	PhaseRequest = "request"
+
+	// PhaseStream is for receiving and processing the chunks
+	PhaseStream = "stream"
+
+	// PhaseVAD is for the detected events and clips
+	PhaseVAD = "vad"
+
+	// PhaseASR is for the speech recognition
+	PhaseASR = "asr"
+
+	// PhaseFlow is for the dialog flow service
+	PhaseFlow = "flow"
+
+	// PhaseResponse is for sending the responses and events
+	PhaseResponse = "response"
+
+	// PhaseClose is for closing the sessions
+	PhaseClose = "close"
+
+	// PhaseServer is for the server wide lines, e.g. shutting down
+	PhaseServer = "server"
+
+	// PhaseAdmin is for the admin endpoints
+	PhaseAdmin = "admin"
+
+	// PhaseConfig is for loading and reloading the config
+	PhaseConfig = "config"
+)
+
+// the output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+var std = logrus.New()
+
+func init() {
+	std.SetOutput(os.Stderr)
+	std.SetFormatter(newFormatter(FormatJSON))
+}
+
+func newFormatter(format string) logrus.Formatter {
+	if format == FormatText {
+		return &logrus.TextFormatter{FullTimestamp: true, TimestampFormat: time.RFC3339Nano}
+	}
+	return &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+}
+
+// Validate checks if the level is one of debug, info, warn and error, and the format is json or text
+func Validate(level, format string) error {
+	_, err := parse(level, format)
+	return err
+}
+
+func parse(level, format string) (logrus.Level, error) {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return l, err
+	}
+	switch l {
+	case logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel:
+	default:
+		return l, fmt.Errorf("level should be debug, info, warn or error, got %q", level)
+	}
+	if format != FormatJSON && format != FormatText {
+		return l, fmt.Errorf("format should be %q or %q, got %q", FormatJSON, FormatText, format)
+	}
+	return l, nil
+}
+
+// Configure sets the level and the output format of the logging
+func Configure(level, format string) error {
+	l, err := parse(level, format)
+	if err != nil {
+		return err
+	}
+	std.SetLevel(l)
+	std.SetFormatter(newFormatter(format))
+	return nil
+}
+
+// IsDebug checks if the debug lines are logged, so that the costly ones can be skipped
+func IsDebug() bool {
+	return std.IsLevelEnabled(logrus.DebugLevel)
+}
+
+// Phase returns the logger of the server wide lines in the phase
+func Phase(phase string) *logrus.Entry {
+	return std.WithField(FieldPhase, phase)
+}
+
+// Conn returns the logger of the connection whose session is not known yet.
+// The session fields are empty until they are set by Session.
+func Conn(remote string) *logrus.Entry {
+	return std.WithFields(logrus.Fields{
+		FieldCID:     "",
+		FieldUID:     "",
+		FieldChannel: "",
+		FieldRemote:  remote,
+	})
+}
+
+// Session returns the logger with the session fields over the connection logger
+func Session(conn *logrus.Entry, cid, uid, channel string) *logrus.Entry {
+	return conn.WithFields(logrus.Fields{
+		FieldCID:     cid,
+		FieldUID:     uid,
+		FieldChannel: channel,
+	})
+}
@@ -0,0 +1,160 @@
+// Package metrics defines the prometheus metrics of the vads server, which are
+// exposed by Handler, e.g. on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "vads"
+
+// the outcomes of the ended sessions
+const (
+	// OutcomeSuccess is the session ended by the client, the hangup or the chunk timeout
+	OutcomeSuccess = "success"
+
+	// OutcomeError is the session failed with an error response
+	OutcomeError = "error"
+
+	// OutcomeCancelled is the session cancelled by the client
+	OutcomeCancelled = "cancelled"
+
+	// OutcomeStopped is the session stopped by the server, e.g. on shutting down
+	OutcomeStopped = "stopped"
+)
+
+// the reasons of the rejected sessions
+const (
+	// RejectShuttingDown is the session rejected since the server is shutting down
+	RejectShuttingDown = "shutting_down"
+
+	// RejectBusy is the session rejected since the server reaches server.max_sessions
+	RejectBusy = "busy"
+
+	// RejectChannelBusy is the session rejected since the channel reaches its limit
+	RejectChannelBusy = "channel_busy"
+)
+
+var (
+	// SessionsStarted counts the sessions started after the request is received
+	SessionsStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_started_total",
+		Help:      "Number of the sessions started.",
+	})
+
+	// SessionsEnded counts the sessions ended by outcome
+	SessionsEnded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_ended_total",
+		Help:      "Number of the sessions ended by outcome.",
+	}, []string{"outcome"})
+
+	// SessionsActive is the number of the running sessions
+	SessionsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sessions_active",
+		Help:      "Number of the running sessions.",
+	})
+
+	// SessionsRejected counts the connections and sessions rejected by reason
+	SessionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_rejected_total",
+		Help:      "Number of the sessions rejected by reason.",
+	}, []string{"reason"})
+
+	// ChunksReceived counts the audio chunks received
+	ChunksReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chunks_received_total",
+		Help:      "Number of the audio chunks received.",
+	})
+
+	// ChunksJitter counts the chunks not received in order, by reordered, lost or dropped
+	ChunksJitter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chunks_jitter_total",
+		Help:      "Number of the audio chunks not received in order by kind, reordered, lost or dropped.",
+	}, []string{"kind"})
+
+	// FramesProcessed counts the frames fed to the detectors
+	FramesProcessed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frames_processed_total",
+		Help:      "Number of the frames processed by the detectors.",
+	})
+
+	// VADEvents counts the events detected by type, voice_begin, voice_end or noinput
+	VADEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vad_events_total",
+		Help:      "Number of the vad events detected by type.",
+	}, []string{"type"})
+
+	// ClipDuration observes the durations of the clips detected in seconds
+	ClipDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "clip_duration_seconds",
+		Help:      "Durations of the clips detected.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 3, 5, 8, 10, 15, 20, 30},
+	})
+
+	// ASRDuration observes the latencies of the recognitions by provider in seconds
+	ASRDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "asr_duration_seconds",
+		Help:      "Latencies of the speech recognitions by provider.",
+		Buckets:   []float64{0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2, 3, 5, 10},
+	}, []string{"provider"})
+
+	// ASRFailures counts the failed recognitions by provider
+	ASRFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "asr_failures_total",
+		Help:      "Number of the failed speech recognitions by provider.",
+	}, []string{"provider"})
+
+	// ResponseLatency observes the latencies from the end of speech detected to the response sent in seconds
+	ResponseLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "response_latency_seconds",
+		Help:      "Latencies from the end of speech detected to the response sent.",
+		Buckets:   []float64{0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2, 3, 5, 10},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		SessionsStarted,
+		SessionsEnded,
+		SessionsActive,
+		SessionsRejected,
+		ChunksReceived,
+		ChunksJitter,
+		FramesProcessed,
+		VADEvents,
+		ClipDuration,
+		ASRDuration,
+		ASRFailures,
+		ResponseLatency,
+	)
+}
+
+// ObserveASR observes the latency of the recognition started at start by the provider,
+// and counts it as failed if ok is false
+func ObserveASR(provider string, start time.Time, ok bool) {
+	ASRDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	if !ok {
+		ASRFailures.WithLabelValues(provider).Inc()
+	}
+}
+
+// Handler returns the http handler exposing the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
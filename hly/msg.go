@@ -14,15 +14,37 @@ const (
 
 	// ResponseType defines the type of response message
 	ResponseType = "response"
+
+	// EventType defines the type of event message
+	EventType = "event"
+
+	// EndOfStreamType defines the type of end-of-stream message
+	EndOfStreamType = "eos"
+
+	// CancelType defines the type of cancel message
+	CancelType = "cancel"
+
+	// AudioType defines the type of binary audio message which carries a chunk without json
+	AudioType = "audio"
 )
 
-// Message is the abstract struct type of Request, Response and Chunk
+// EnvelopeVersion is the version of the message envelope on the wire
+const EnvelopeVersion = 1
+
+// Envelope is the versioned frame on the wire which declares the type of the message in it
+type Envelope struct {
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Message is the abstract struct type of Request, Response, Chunk, Event, EndOfStream and Cancel
 type Message struct {
 	Type    string
 	Payload Payload
 }
 
-// Payload can be Request, Chunk or Response
+// Payload can be Request, Chunk, Response, Event, EndOfStream or Cancel
 type Payload interface{}
 
 // ParseRequestOnWire parsea bytes on wire to request
@@ -65,8 +87,149 @@ func ParseResponseOnWire(bytes []byte) (*Message, error) {
 	return nil, fmt.Errorf("message error - fail to unmarshal bytes to response, error: %v\n%v", err, string(bytes))
 }
 
+// ParseEventOnWire parsea bytes on wire to event
+func ParseEventOnWire(bytes []byte) (*Message, error) {
+	var evt Event
+	err := json.Unmarshal(bytes, &evt)
+	if err == nil {
+		return &Message{
+			Type:    EventType,
+			Payload: &evt,
+		}, nil
+	}
+	return nil, fmt.Errorf("message error - fail to unmarshal bytes to event, error: %v\n%v", err, string(bytes))
+}
+
+// ParseEndOfStreamOnWire parsea bytes on wire to end-of-stream
+func ParseEndOfStreamOnWire(bytes []byte) (*Message, error) {
+	var eos EndOfStream
+	err := json.Unmarshal(bytes, &eos)
+	if err == nil {
+		return &Message{
+			Type:    EndOfStreamType,
+			Payload: &eos,
+		}, nil
+	}
+	return nil, fmt.Errorf("message error - fail to unmarshal bytes to end-of-stream, error: %v\n%v", err, string(bytes))
+}
+
+// ParseCancelOnWire parsea bytes on wire to cancel
+func ParseCancelOnWire(bytes []byte) (*Message, error) {
+	var cnl Cancel
+	err := json.Unmarshal(bytes, &cnl)
+	if err == nil {
+		return &Message{
+			Type:    CancelType,
+			Payload: &cnl,
+		}, nil
+	}
+	return nil, fmt.Errorf("message error - fail to unmarshal bytes to cancel, error: %v\n%v", err, string(bytes))
+}
+
+// IsAudioOnWire checks if the bytes on wire is a binary audio frame
+func IsAudioOnWire(bytes []byte) bool {
+	return len(bytes) > 0 && bytes[0] == audioMarker
+}
+
+// ParseAudioOnWire parsea binary audio frame on wire to chunk
+func ParseAudioOnWire(bytes []byte) (*Message, error) {
+	var chk Chunk
+	err := chk.DecodeBinary(bytes)
+	if err == nil {
+		return &Message{
+			Type:    AudioType,
+			Payload: &chk,
+		}, nil
+	}
+	return nil, fmt.Errorf("message error - fail to decode bytes to audio, error: %v", err)
+}
+
+// ParseServerMessageOnWire parsea bytes sent by server on wire to event or response
+func ParseServerMessageOnWire(bytes []byte) (*Message, error) {
+	var probe struct {
+		Event *string `json:"event"`
+	}
+	err := json.Unmarshal(bytes, &probe)
+	if err != nil {
+		return nil, fmt.Errorf("message error - fail to unmarshal bytes to server message, error: %v\n%v", err, string(bytes))
+	}
+	if probe.Event != nil {
+		return ParseEventOnWire(bytes)
+	}
+	return ParseResponseOnWire(bytes)
+}
+
+// IsEnvelopeOnWire checks if the bytes on wire is an enveloped message by the type field
+func IsEnvelopeOnWire(bytes []byte) bool {
+	var probe struct {
+		Type *string `json:"type"`
+	}
+	err := json.Unmarshal(bytes, &probe)
+	return err == nil && probe.Type != nil
+}
+
+// ParseEnvelopeOnWire parsea enveloped bytes on wire to message in term of the declared type
+func ParseEnvelopeOnWire(bytes []byte) (*Message, error) {
+	var env Envelope
+	err := json.Unmarshal(bytes, &env)
+	if err != nil {
+		return nil, fmt.Errorf("message error - fail to unmarshal bytes to envelope, error: %v\n%v", err, string(bytes))
+	}
+	if env.Version < 1 || env.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("message error - unsupported envelope version %v, want 1 to %v", env.Version, EnvelopeVersion)
+	}
+	if len(env.Payload) == 0 {
+		return nil, fmt.Errorf("message error - empty payload in %v envelope", env.Type)
+	}
+
+	switch env.Type {
+	case RequestType:
+		return ParseRequestOnWire(env.Payload)
+	case ChunkType:
+		return ParseChunkOnWire(env.Payload)
+	case ResponseType:
+		return ParseResponseOnWire(env.Payload)
+	case EventType:
+		return ParseEventOnWire(env.Payload)
+	case EndOfStreamType:
+		return ParseEndOfStreamOnWire(env.Payload)
+	case CancelType:
+		return ParseCancelOnWire(env.Payload)
+	default:
+		return nil, fmt.Errorf("message error - illegal message type %q in envelope", env.Type)
+	}
+}
+
+// EnvelopeOnWire returns the bytes of the enveloped messsage on the wire.
+// The binary audio message is never enveloped.
+func (m *Message) EnvelopeOnWire() ([]byte, error) {
+	if m.Type == AudioType {
+		return m.BytesOnWire()
+	}
+	payload, err := m.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := json.Marshal(&Envelope{
+		Version: EnvelopeVersion,
+		Type:    m.Type,
+		Payload: payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("message error - fail to marshal envelope to bytes, error: %v", err)
+	}
+	return bytes, nil
+}
+
 // BytesOnWire returns the bytes of the messsage on the wire.
 func (m *Message) BytesOnWire() ([]byte, error) {
+	if m.Type == AudioType {
+		chk, ok := m.Payload.(*Chunk)
+		if !ok {
+			return nil, fmt.Errorf("message error - payload is not a %v", m.Type)
+		}
+		return chk.EncodeBinary(), nil
+	}
 	bytes, err := json.Marshal(m)
 	if err != nil {
 		return nil, fmt.Errorf("message error - fail to marshal message to bytes, error: %v", err)
@@ -105,6 +268,24 @@ func (m Message) MarshalJSON() ([]byte, error) {
 			return nil, fmt.Errorf("message error - payload is not a %v", m.Type)
 		}
 		return json.Marshal(res)
+	case EventType:
+		evt, ok := m.Payload.(*Event)
+		if !ok {
+			return nil, fmt.Errorf("message error - payload is not a %v", m.Type)
+		}
+		return json.Marshal(evt)
+	case EndOfStreamType:
+		eos, ok := m.Payload.(*EndOfStream)
+		if !ok {
+			return nil, fmt.Errorf("message error - payload is not a %v", m.Type)
+		}
+		return json.Marshal(eos)
+	case CancelType:
+		cnl, ok := m.Payload.(*Cancel)
+		if !ok {
+			return nil, fmt.Errorf("message error - payload is not a %v", m.Type)
+		}
+		return json.Marshal(cnl)
 	default:
 		return nil, fmt.Errorf("message error - illegal message type %v", m.Type)
 	}
@@ -128,7 +309,7 @@ func (m *Message) Response() (*Response, error) {
 	return obj, nil
 }
 
-// Chunk returns the pointer of the un-marshaled Chunk obj from payload
+// Chunk returns the pointer of the un-marshaled Chunk obj from payload of chunk or audio message
 func (m *Message) Chunk() (*Chunk, error) {
 	obj, ok := m.Payload.(*Chunk)
 	if !ok {
@@ -136,3 +317,30 @@ func (m *Message) Chunk() (*Chunk, error) {
 	}
 	return obj, nil
 }
+
+// Event returns the pointer of the un-marshaled Event obj from payload
+func (m *Message) Event() (*Event, error) {
+	obj, ok := m.Payload.(*Event)
+	if !ok {
+		return nil, fmt.Errorf("message error - payload is not a %v but %v", EventType, m.Type)
+	}
+	return obj, nil
+}
+
+// EndOfStream returns the pointer of the un-marshaled EndOfStream obj from payload
+func (m *Message) EndOfStream() (*EndOfStream, error) {
+	obj, ok := m.Payload.(*EndOfStream)
+	if !ok {
+		return nil, fmt.Errorf("message error - payload is not a %v but %v", EndOfStreamType, m.Type)
+	}
+	return obj, nil
+}
+
+// Cancel returns the pointer of the un-marshaled Cancel obj from payload
+func (m *Message) Cancel() (*Cancel, error) {
+	obj, ok := m.Payload.(*Cancel)
+	if !ok {
+		return nil, fmt.Errorf("message error - payload is not a %v but %v", CancelType, m.Type)
+	}
+	return obj, nil
+}
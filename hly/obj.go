@@ -3,16 +3,50 @@ package hly
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/henryleu/go-vad"
 )
 
 /*
 	基本信息
 	Port:6000
 	数据格式：2字节（网络字节顺序，表示json长度）+json数据串
-	连接方式：websocket
+	连接方式：websocket 或 tcp
+		websocket：默认每个二进制帧为json数据串；地址带codec=framed参数时，每个二进制帧按上述数据格式
+		tcp：按上述数据格式连续收发，服务器发送完毕后关闭写端
+	并发限制：服务器并发会话数超限时，websocket连接以503拒绝升级；tcp连接不等待请求，立即返回繁忙应答（cid为空）并关闭连接；
+		单渠道并发会话数超限时，服务器返回繁忙应答（code为2，error为overload，status为1），然后以1013（稍后重试）关闭连接，
+		客户端可换其它服务器重试
 	备注：语音格式（单声道，采样率16K,位深16bit）
 */
 
+/*
+	消息信封（version 1）
+	格式：
+	{
+		"version": 1,
+		"type": "chunk",
+		"payload": {
+			"cid": "01010101010",
+			"chunk": 1,
+			"audio": "xxxxxxx"
+		}
+	}
+
+	参数说明
+	字段				类型			说明
+	----------------------------------------
+	version		int				信封版本，目前为1
+	type			string		消息类型：request 请求；chunk 分片；response 应答；event 事件
+	payload		json			消息内容，即下述各类型消息
+
+	备注：不带type字段的消息按旧格式处理（第一条为请求，之后均为分片），
+	服务器以客户端第一条消息的格式（信封或旧格式）返回应答和事件
+*/
+
 /*
 	客户端请求
 	第一次发送http请求内容
@@ -20,6 +54,15 @@ import (
 	{
 		"cid": "01010101010",
 		"rate": "16000",
+		"mode": "single",
+		"events": false,
+		"transfer": "json",
+		"encoding": "pcm16",
+		"profile": "yesno",
+		"vad": {
+			"silence_timeout": 800,
+			"vad_level": 3
+		},
 		"business": {
 			"uid": "1331114444 abcd",
 			"province": "beijing",
@@ -31,20 +74,130 @@ import (
 	字段				类型			说明
 	----------------------------------------
 	cid				string		连接会话唯一标识
-	rate			string		采样率(目前仅支持16k,后期可修改）
+	rate			string		采样率，支持4000、8000、11025、12000、16000、22050、24000、32000、44100、48000、88200、96000、176400、192000（默认8000），8000和16000以外的采样率由服务器重采样到16000（低于8000时重采样到8000）
+	mode			string		识别模式：single 单句识别（默认），返回一次结果后断开；multiple 多句识别，每句返回一次结果，直到客户端断开或挂机
+	events		bool			是否推送VAD事件（默认false），开启后服务器在检测到语音开始、语音结束和无输入时推送事件消息
+	transfer	string		语音传输方式：json 分片为json数据串，音频采用base64编码（默认）；binary 分片为二进制音频帧
+	profile		string		配置方案名称（可选），指定服务器配置中的VAD参数和识别引擎方案；不指定时按渠道、省份和被叫号码前缀匹配服务器配置的规则选择，均未匹配时使用默认配置；方案不存在时服务器返回失败应答
+	vad				json			VAD参数（可选），覆盖服务器默认的端点检测参数，未指定的字段使用默认值：
+										speech_timeout 语音开始所需的持续语音时长（毫秒）；silence_timeout 语音结束所需的持续静音时长（毫秒）；
+										noinput_timeout 无输入超时（毫秒）；recognition_timeout 识别超时（毫秒）；vad_level 检测灵敏度0-3，越大越抗噪
+										参数非法时服务器返回失败应答
+	encoding	string		音频编码：pcm16 16bit线性PCM（默认）；alaw G.711 A律；ulaw G.711 μ律，G.711音频为每采样8bit，由服务器解码为16bit线性PCM
 	uid				string		用户唯一标识，建议由字母大小写及数字组成，一定要保证一个UID代表一个用户的终身ID
 	province	string		用户号码省份
 	channel		string		渠道
 
 */
 
+const (
+	// ModeSingle detects only one utterance and closes the session after the response
+	ModeSingle = "single"
+
+	// ModeMultiple detects every utterance and responses for each one on the same session
+	ModeMultiple = "multiple"
+)
+
+const (
+	// TransferJSON sends audio in json chunks with base64 encoded audio
+	TransferJSON = "json"
+
+	// TransferBinary sends audio in binary audio frames
+	TransferBinary = "binary"
+)
+
+const (
+	// EncodingPCM16 is 16 bits linear pcm in little endian
+	EncodingPCM16 = "pcm16"
+
+	// EncodingALaw is 8 bits G.711 A-law
+	EncodingALaw = "alaw"
+
+	// EncodingULaw is 8 bits G.711 μ-law
+	EncodingULaw = "ulaw"
+)
+
+// DefaultRate is the sample rate of the session if the rate of the request is empty
+const DefaultRate = 8000
+
+// SupportedRates are the sample rates accepted in the request. The rates are limited to the
+// common ones, since the resampling filter of an arbitrary rate may take tens of megabytes.
+var SupportedRates = []int{4000, 8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000, 88200, 96000, 176400, 192000}
+
+// CancelledDetail is the detail of the response for the session cancelled by client
+const CancelledDetail = "cancelled"
+
+// CodeBusy is the result code of the session rejected since the server or the channel
+// reaches the max number of the concurrent sessions, and it can be retried on another server
+const CodeBusy = 2
+
+// BusyDetail is the detail of the response for the session rejected by the server limit
+const BusyDetail = "server busy, too many sessions"
+
 // Request is the session request
 type Request struct {
 	CID      string    `json:"cid"`
 	Rate     string    `json:"rate"`
+	Mode     string    `json:"mode,omitempty"`
+	Events   bool      `json:"events,omitempty"`
+	Transfer string    `json:"transfer,omitempty"`
+	Encoding string    `json:"encoding,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+	VAD      *VAD      `json:"vad,omitempty"`
 	Business *Business `json:"business"`
 }
 
+// Multiple indicates if the session is in multiple utterance mode
+func (o *Request) Multiple() bool {
+	return o.Mode == ModeMultiple
+}
+
+// SampleRate parses the rate of the request and checks if it is supported.
+// 8000 is used if the rate is empty.
+func (o *Request) SampleRate() (int, error) {
+	if o.Rate == "" {
+		return DefaultRate, nil
+	}
+	rate, err := strconv.Atoi(o.Rate)
+	if err != nil {
+		return 0, fmt.Errorf("request error - illegal rate %q, error: %v", o.Rate, err)
+	}
+	for _, r := range SupportedRates {
+		if rate == r {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("request error - unsupported rate %v, it should be one of %v", rate, SupportedRates)
+}
+
+// Binary indicates if the audio is sent in binary audio frames
+func (o *Request) Binary() bool {
+	return o.Transfer == TransferBinary
+}
+
+// Validate checks if the rate, mode, transfer and encoding of the request are supported
+func (o *Request) Validate() error {
+	if _, err := o.SampleRate(); err != nil {
+		return err
+	}
+	switch o.Mode {
+	case "", ModeSingle, ModeMultiple:
+	default:
+		return fmt.Errorf("request error - illegal mode %q, it should be %q or %q", o.Mode, ModeSingle, ModeMultiple)
+	}
+	switch o.Transfer {
+	case "", TransferJSON, TransferBinary:
+	default:
+		return fmt.Errorf("request error - illegal transfer %q, it should be %q or %q", o.Transfer, TransferJSON, TransferBinary)
+	}
+	switch o.Encoding {
+	case "", EncodingPCM16, EncodingALaw, EncodingULaw:
+	default:
+		return fmt.Errorf("request error - illegal encoding %q, it should be %q, %q or %q", o.Encoding, EncodingPCM16, EncodingALaw, EncodingULaw)
+	}
+	return nil
+}
+
 // Message creates a request Message
 func (o *Request) Message() *Message {
 	return &Message{
@@ -53,8 +206,17 @@ func (o *Request) Message() *Message {
 	}
 }
 
-// NewErrorResponse creates and returns a new response with error result
-func (o *Request) NewErrorResponse(detail string) *Response {
+// NewErrorResponse creates and returns a new response with error result, which carries
+// the code of the error in the catalog
+func (o *Request) NewErrorResponse(err *Error) *Response {
+	res := o.newFailedResponse(err.Detail)
+	res.Result.Error = err.Code.String()
+	res.Result.ErrorCode = int(err.Code)
+	return res
+}
+
+// newFailedResponse creates and returns a new response with failed result without error code
+func (o *Request) newFailedResponse(detail string) *Response {
 	return &Response{
 		CID: o.CID,
 		Result: &Result{
@@ -89,6 +251,71 @@ func (o *Request) NewSuccessResponse(status int, recognition *Recognition) *Resp
 	}
 }
 
+// NewCancelResponse creates and returns a new response for the session cancelled by client
+func (o *Request) NewCancelResponse() *Response {
+	return o.newFailedResponse(CancelledDetail)
+}
+
+// NewBusyResponse creates and returns a new response for the session rejected since the
+// server is busy, whose code is CodeBusy and error code is ErrOverload
+func (o *Request) NewBusyResponse(detail string) *Response {
+	res := o.NewErrorResponse(newError(ErrOverload, "%v", detail))
+	res.Result.Code = CodeBusy
+	return res
+}
+
+// uid returns the uid of the business info, or empty if it is not specified
+func (o *Request) uid() string {
+	if o.Business == nil {
+		return ""
+	}
+	return o.Business.UID
+}
+
+// channel returns the channel of the business info, or empty if it is not specified
+func (o *Request) channel() string {
+	if o.Business == nil {
+		return ""
+	}
+	return o.Business.Channel
+}
+
+// VAD is the vad params of the request overriding the default ones of the server.
+// The params not specified are left as default.
+type VAD struct {
+	SpeechTimeout      *int `json:"speech_timeout,omitempty"`
+	SilenceTimeout     *int `json:"silence_timeout,omitempty"`
+	NoinputTimeout     *int `json:"noinput_timeout,omitempty"`
+	RecognitionTimeout *int `json:"recognition_timeout,omitempty"`
+	VADLevel           *int `json:"vad_level,omitempty"`
+}
+
+// Apply overrides the detector config with the specified vad params and validates it
+func (o *VAD) Apply(c *vad.Config) error {
+	if o == nil {
+		return nil
+	}
+	if o.SpeechTimeout != nil {
+		c.SpeechTimeout = *o.SpeechTimeout
+	}
+	if o.SilenceTimeout != nil {
+		c.SilenceTimeout = *o.SilenceTimeout
+	}
+	if o.NoinputTimeout != nil {
+		c.NoinputTimeout = *o.NoinputTimeout
+	}
+	if o.RecognitionTimeout != nil {
+		c.RecognitionTimeout = *o.RecognitionTimeout
+	}
+	if o.VADLevel != nil {
+		c.VADLevel = vad.Level(*o.VADLevel)
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("request error - illegal vad params, error: %v", err)
+	}
+	return nil
+}
+
 // Business is the biz info in the inbound session message
 type Business struct {
 	UID      string `json:"uid"`
@@ -108,14 +335,24 @@ type Business struct {
 	参数说明
 	字段				类型			说明
 	----------------------------------------
-	chunk				int			该分片在所有分片中的编号
-	audio				string	音频内容（每次时长100ms,采用base64编码）
+	chunk				int			该分片在所有分片中的编号，从1开始；乱序到达的分片在5个分片的窗口内重排，超出窗口仍未到达的分片以静音填充，迟到或重复的分片丢弃，并在应答detail中注明；编号超前50个以上的分片视为非法
+	audio				string	音频内容（建议每次时长100ms,采用base64编码），长度不限，不足一帧（20ms）的部分由服务器与后续分片拼接
 
 	服务器端返回结果：
 	备注：要求client第二次收到内容后断开
 
+	二进制音频帧（请求中transfer为binary时）
+	格式：1字节帧类型（0x01）+ 4字节分片编号（网络字节顺序）+ 音频数据（不编码）
+	备注：二进制音频帧不带信封，与json消息（如eos、cancel）可在同一连接中混合发送；
+	使用framed编码时，二进制音频帧同样带2字节长度前缀
 */
 
+// audioMarker is the first byte of the binary audio frame, which is never the first byte of a json message
+const audioMarker = 0x01
+
+// audioHeaderLen is the length of the header of the binary audio frame
+const audioHeaderLen = 5
+
 // Chunk is the chunk data of the inbound voice in the session
 type Chunk struct {
 	CID   string `json:"cid"`
@@ -157,6 +394,132 @@ func (o *Chunk) Message() *Message {
 	}
 }
 
+// AudioMessage creates a binary audio Message
+func (o *Chunk) AudioMessage() *Message {
+	return &Message{
+		Type:    AudioType,
+		Payload: o,
+	}
+}
+
+// EncodeBinary encodes the chunk no and audio bytes to a binary audio frame
+func (o *Chunk) EncodeBinary() []byte {
+	frame := make([]byte, audioHeaderLen+len(o.Data))
+	frame[0] = audioMarker
+	binary.BigEndian.PutUint32(frame[1:], uint32(o.NO))
+	copy(frame[audioHeaderLen:], o.Data)
+	return frame
+}
+
+// DecodeBinary decodes the chunk no and audio bytes from a binary audio frame
+func (o *Chunk) DecodeBinary(frame []byte) error {
+	if len(frame) < audioHeaderLen || frame[0] != audioMarker {
+		return fmt.Errorf("chunk error - illegal binary audio frame with %v bytes", len(frame))
+	}
+	o.NO = int(binary.BigEndian.Uint32(frame[1:]))
+	o.Data = frame[audioHeaderLen:]
+	return nil
+}
+
+/*
+	客户端结束发送语音（仅支持信封格式，type为eos）
+	格式：
+	{
+		"cid": "01010101010"
+	}
+
+	服务器返回结果：
+	立即结束检测并识别已收到的语音。单句识别返回一次应答，没有检测到语音时返回无输入的失败应答；
+	多句识别返回尚未应答的语音的应答。最后服务器以1000（正常关闭）关闭连接。
+
+	客户端取消会话（仅支持信封格式，type为cancel）
+	格式：
+	{
+		"cid": "01010101010",
+		"reason": "caller hung up"
+	}
+
+	参数说明
+	字段				类型			说明
+	----------------------------------------
+	reason		string		取消原因（可选），仅用于日志
+
+	服务器返回结果：
+	放弃检测和识别，返回失败应答（code为0，status为1，detail为cancelled），然后以1000（正常关闭）关闭连接。
+*/
+
+// EndOfStream is sent by client when all the audio of the session is sent
+type EndOfStream struct {
+	CID string `json:"cid"`
+}
+
+// Message creates an end-of-stream Message
+func (o *EndOfStream) Message() *Message {
+	return &Message{
+		Type:    EndOfStreamType,
+		Payload: o,
+	}
+}
+
+// Cancel is sent by client to abort the session without recognition
+type Cancel struct {
+	CID    string `json:"cid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Message creates a cancel Message
+func (o *Cancel) Message() *Message {
+	return &Message{
+		Type:    CancelType,
+		Payload: o,
+	}
+}
+
+/*
+	服务器推送VAD事件（请求中events为true时）
+	格式：
+	{
+		"cid": "01010101010",
+		"event": "voice_begin",
+		"offset": 1280
+	}
+
+	字段说明
+	字段					类型			说明
+	----------------------------------------
+	cid					string		连接会话唯一标识
+	event				string		事件类型：voice_begin 语音开始；voice_end 语音结束；noinput 无输入
+	offset			int				事件检测到时在输入语音流中的位置（毫秒）
+
+	备注：多句识别模式下每句推送voice_begin和voice_end事件，不推送noinput事件
+*/
+
+const (
+	// EventVoiceBegin is pushed when voice is detected
+	EventVoiceBegin = "voice_begin"
+
+	// EventVoiceEnd is pushed when voice is over
+	EventVoiceEnd = "voice_end"
+
+	// EventNoinput is pushed when no input is detected until the noinput timeout
+	EventNoinput = "noinput"
+)
+
+// Event is the voice activity event pushed by server
+type Event struct {
+	CID    string `json:"cid"`
+	Event  string `json:"event"`
+	Offset int    `json:"offset"`
+}
+
+// Message creates an event Message
+func (o *Event) Message() *Message {
+	return &Message{
+		Type:    EventType,
+		Payload: o,
+	}
+}
+
 /*
 	客户端请求
 	第一次发送http请求内容
@@ -183,7 +546,9 @@ func (o *Chunk) Message() *Message {
 	字段					类型			说明
 	----------------------------------------
 	cid					string		连接会话唯一标识
-	code				int				处理结果 1：成功; 0：失败;
+	code				int				处理结果 1：成功; 0：失败; 2：服务繁忙（并发会话数超限），可换其它服务器重试;
+	error				string		错误名称（失败时），如decode_error，见错误码
+	error_code	int				错误编号（失败时），如4，见错误码
 	status			int				挂机处置方式：0：继续对话；1：结束对话；
 	detail			string		失败原因描述
 	audio_text	string		语音识别文本
@@ -207,7 +572,14 @@ func (o *Response) Message() *Message {
 
 // Result descries the result infos of the response
 type Result struct {
-	Code   int     `json:"code"`
+	Code int `json:"code"`
+
+	// Error is the name of the error code in the catalog, e.g. decode_error, and empty if not failed
+	Error string `json:"error,omitempty"`
+
+	// ErrorCode is the error code in the catalog, and 0 if not failed
+	ErrorCode int `json:"error_code,omitempty"`
+
 	Detail string  `json:"detail"`
 	Return *Return `json:"ret"`
 }
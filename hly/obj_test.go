@@ -0,0 +1,60 @@
+package hly
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/henryleu/vads/hly/audio"
+)
+
+// maxResamplerBytes is the max bytes allocated for the resampler of a supported rate
+const maxResamplerBytes = 1 << 20
+
+func TestSampleRate(t *testing.T) {
+	tests := []struct {
+		rate string
+		want int
+		ok   bool
+	}{
+		{"", DefaultRate, true},
+		{"8000", 8000, true},
+		{"44100", 44100, true},
+		{"192000", 192000, true},
+		{"191999", 0, false},
+		{"176401", 0, false},
+		{"7999", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		req := &Request{Rate: tt.rate}
+		got, err := req.SampleRate()
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("SampleRate(%q) = %v, %v, want %v, ok %v", tt.rate, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestSupportedRatesAllocation pins the memory of the resamplers of the supported rates,
+// so that no rate added to SupportedRates can be used to exhaust the memory
+func TestSupportedRatesAllocation(t *testing.T) {
+	for _, rate := range SupportedRates {
+		native := getNativeRate(rate)
+		if native == rate {
+			continue
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		r, err := audio.NewResampler(rate, native)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Errorf("NewResampler(%v, %v) error = %v", rate, native, err)
+			continue
+		}
+		if n := r.FilterSize(); n > audio.MaxFilterSize {
+			t.Errorf("NewResampler(%v, %v) filter size = %v, want <= %v", rate, native, n, audio.MaxFilterSize)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > maxResamplerBytes {
+			t.Errorf("NewResampler(%v, %v) allocated %v bytes, want <= %v", rate, native, n, maxResamplerBytes)
+		}
+	}
+}
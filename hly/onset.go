@@ -0,0 +1,80 @@
+package hly
+
+import (
+	"github.com/henryleu/go-vad"
+	webrtcvad "github.com/henryleu/go-webrtcvad"
+)
+
+// onsetDetector detects the beginning of the voice in multiple mode, in which the detector
+// emits no voice begin event. It follows the activity transition of the detector by the vad
+// result of every frame, so the voice begins at the same frame as the clip detected later.
+type onsetDetector struct {
+	vad *webrtcvad.VAD
+
+	// sampleRate is the sample rate of the frames
+	sampleRate int
+
+	// frameDuration is the duration of a frame in milliseconds
+	frameDuration int
+
+	// speechTimeout is the duration of the activity in milliseconds before the voice begins
+	speechTimeout int
+
+	// transition indicates if the activity is detected but the voice doesn't begin yet
+	transition bool
+
+	// duration is the duration of the activity transition in milliseconds
+	duration int
+
+	// speaking indicates if the voice begins and the clip is not detected yet
+	speaking bool
+}
+
+func newOnsetDetector(c *vad.Config, sampleRate, frameDuration int) (*onsetDetector, error) {
+	v, err := webrtcvad.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := v.SetMode(int(c.VADLevel)); err != nil {
+		return nil, err
+	}
+	return &onsetDetector{
+		vad:           v,
+		sampleRate:    sampleRate,
+		frameDuration: frameDuration,
+		speechTimeout: c.SpeechTimeout,
+	}, nil
+}
+
+// Process processes the frame and returns true if the voice begins at the frame.
+// Every frame fed to the detector should be processed in order.
+func (o *onsetDetector) Process(frame []byte) (begin bool, err error) {
+	active, err := o.vad.Process(o.sampleRate, frame)
+	if err != nil {
+		return false, err
+	}
+	if o.speaking {
+		return false, nil
+	}
+	switch {
+	case !active:
+		o.transition = false
+	case !o.transition:
+		o.transition = true
+		o.duration = 0
+	default:
+		o.duration += o.frameDuration
+		if o.duration >= o.speechTimeout {
+			o.transition = false
+			o.speaking = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// End ends the voice once its clip is detected
+func (o *onsetDetector) End() {
+	o.speaking = false
+	o.transition = false
+}
@@ -0,0 +1,88 @@
+package hly
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/henryleu/go-vad"
+)
+
+// TestOnsetDetector checks if the voice begins ahead of every clip detected in multiple mode
+// by the speech timeout, i.e. the voice_begin offset lines up with the start of the clip,
+// while the detector is renewed after every clip.
+func TestOnsetDetector(t *testing.T) {
+	voice := testVoice(t)
+	tests := []struct {
+		speechTimeout  int
+		silenceTimeout int
+		vadLevel       int
+	}{
+		{200, 500, 2},
+		{100, 300, 3},
+		{250, 800, 1},
+		{20, 200, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("speech%v-silence%v-level%v", tt.speechTimeout, tt.silenceTimeout, tt.vadLevel), func(t *testing.T) {
+			tr := newMemTransport()
+			go func() {
+				for range tr.out {
+				}
+			}()
+			t.Cleanup(func() { close(tr.out) })
+			req := &Request{CID: "c1", Mode: ModeMultiple, Events: true}
+			s := newSession(newWire(tr), req, testConfig(t, "http://127.0.0.1:1"))
+			s.rate = DefaultRate
+			s.vadConfig = vad.NewDefaultConfig()
+			s.vadConfig.Multiple = true
+			s.vadConfig.SampleRate = DefaultRate
+			s.vadConfig.SpeechTimeout = tt.speechTimeout
+			s.vadConfig.SilenceTimeout = tt.silenceTimeout
+			s.vadConfig.VADLevel = vad.Level(tt.vadLevel)
+			if e := s.newDetector(); e != nil {
+				t.Fatal(e)
+			}
+			s.events = make(chan *detectedEvent, len(voice)/s.detector.BytesPerFrame())
+
+			n := s.detector.BytesPerFrame()
+			for i := 0; i+n <= len(voice); i += n {
+				if _, e := s.processFrame(voice[i : i+n]); e != nil {
+					t.Fatal(e)
+				}
+				if s.clipNo != 0 {
+					t.Fatalf("detector isn't renewed after the clip at %vms", s.offset)
+				}
+			}
+			// the detector caches the voice since the last clip only
+			if cached, want := len(s.detector.GetTotalClip().Data), (s.offset-s.detectorOffset)/frameDuration*n; s.detectorOffset == 0 || cached != want {
+				t.Errorf("detector caches %v bytes since %vms, want %v", cached, s.detectorOffset, want)
+			}
+			s.closeMultiple()
+
+			// the voice begins at the end of the frame which completes the speech timeout
+			lead := (tt.speechTimeout + frameDuration - 1) / frameDuration * frameDuration
+			var begin, clips int
+			for e := range s.events {
+				switch e.Type {
+				case vad.EventVoiceBegin:
+					if begin != 0 {
+						t.Fatalf("voice begins again at %vms before the clip is detected", e.offset)
+					}
+					begin = e.offset
+				case vad.EventVoiceEnd:
+					if begin == 0 {
+						t.Fatalf("clip %v is detected at %vms without voice begin", clips, e.offset)
+					}
+					if begin-e.Clip.Start != lead {
+						t.Errorf("clip %v starts at %vms, but the voice begins at %vms, want %vms ahead", clips, e.Clip.Start, begin, lead)
+					}
+					begin = 0
+					clips++
+				}
+			}
+			if clips < 2 {
+				t.Errorf("%v clips are detected, want more than 1", clips)
+			}
+		})
+	}
+}
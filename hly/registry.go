@@ -0,0 +1,82 @@
+package hly
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// StateStarting means the request is being validated and the detector is being initialized
+	StateStarting = "starting"
+
+	// StateListening means the voice is being detected in the inbound chunks
+	StateListening = "listening"
+
+	// StateSpeaking means the voice begins, which is known in multiple mode only if the events are pushed
+	StateSpeaking = "speaking"
+
+	// StateRecognizing means the voice ends and the utterances are being recognized
+	StateRecognizing = "recognizing"
+
+	// StateClosing means the session is responding the last result and closing
+	StateClosing = "closing"
+)
+
+// TerminatedReason is the close reason of the sessions terminated by admin
+const TerminatedReason = "terminated by admin"
+
+// SessionInfo is the snapshot of a running session in the registry
+type SessionInfo struct {
+	CID        string    `json:"cid"`
+	Business   *Business `json:"business,omitempty"`
+	Mode       string    `json:"mode,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+	Chunks     int       `json:"chunks"`
+	State      string    `json:"state"`
+	RemoteAddr string    `json:"remote_addr"`
+}
+
+// Sessions returns the snapshots of the running sessions in the order of the start time
+func (srv *Server) Sessions() []*SessionInfo {
+	srv.mutex.Lock()
+	infos := make([]*SessionInfo, 0, len(srv.sessions))
+	for s := range srv.sessions {
+		infos = append(infos, s.info())
+	}
+	srv.mutex.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartTime.Before(infos[j].StartTime)
+	})
+	return infos
+}
+
+// Session returns the snapshots of the running sessions with the cid,
+// and there may be more than one since cid is given by client.
+func (srv *Server) Session(cid string) []*SessionInfo {
+	var infos []*SessionInfo
+	for _, info := range srv.Sessions() {
+		if info.CID == cid {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Terminate stops the running sessions with the cid, which respond ErrTerminated with the reason
+// as error detail. It returns the number of the sessions terminated, and the number of the ones
+// which are not interrupted since they are recognizing the last utterance or closing.
+func (srv *Server) Terminate(cid, reason string) (terminated, closing int) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	for s := range srv.sessions {
+		if s.req.CID != cid {
+			continue
+		}
+		if s.stop(newError(ErrTerminated, "%v", reason)) {
+			terminated++
+		} else {
+			closing++
+		}
+	}
+	return
+}
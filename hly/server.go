@@ -0,0 +1,360 @@
+package hly
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/henryleu/vads/hly/config"
+	"github.com/henryleu/vads/hly/logging"
+	"github.com/henryleu/vads/hly/metrics"
+)
+
+// ShuttingDownReason is the error detail of the sessions stopped on shutting down
+const ShuttingDownReason = "server shutting down"
+
+// maxBusyResponses is the max number of the busy responses being sent on the raw tcp
+// connections rejected, beyond which the connections are closed at once
+const maxBusyResponses = 16
+
+// busyTimeout is the time to send the busy response on a raw tcp connection rejected
+const busyTimeout = time.Second
+
+// ErrServerClosed is returned by Server.ServeTCP after Server.Shutdown is called
+var ErrServerClosed = fmt.Errorf("server error - server closed")
+
+// Server serves the sessions on websocket and raw tcp connections. It tracks the
+// active connections, so that Shutdown drains them before the process exits.
+type Server struct {
+	mutex sync.Mutex
+
+	// sessions are the running sessions
+	sessions map[*session]struct{}
+
+	// listeners are the raw tcp listeners served
+	listeners map[net.Listener]struct{}
+
+	// conns is the number of the connections served, including the ones waiting for the request
+	conns sync.WaitGroup
+
+	// active is the number of the connections admitted, which is limited by server.max_sessions
+	active int
+
+	// channels are the numbers of the running sessions by channel
+	channels map[string]int
+
+	// closing is closed when the server starts shutting down
+	closing      chan struct{}
+	shuttingDown bool
+
+	// stopping indicates if the sessions are being stopped since the shutdown deadline is exceeded
+	stopping bool
+
+	// probes caches the probe results of the services for the readiness checks
+	probes *probeCache
+
+	// busy limits the busy responses being sent on the raw tcp connections rejected
+	busy chan struct{}
+}
+
+// NewServer creates a server
+func NewServer() *Server {
+	return &Server{
+		sessions:  make(map[*session]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		channels:  make(map[string]int),
+		probes:    newProbeCache(),
+		closing:   make(chan struct{}),
+		busy:      make(chan struct{}, maxBusyResponses),
+	}
+}
+
+// DefaultServer is the server used by HandleMRCP and ServeTCP
+var DefaultServer = NewServer()
+
+// HandleMRCP is the websocket handler of the server.
+// The frames are bare JSON messages by default, or length-prefixed JSON messages
+// if the codec query parameter is "framed", e.g. /websocket/hly/calling?codec=framed
+// The upgrade is rejected with 503 once the server is shutting down or the sessions
+// reach server.max_sessions.
+func (srv *Server) HandleMRCP(w http.ResponseWriter, r *http.Request) {
+	logger := logging.Conn(r.RemoteAddr).WithField(logging.FieldPhase, logging.PhaseConnect)
+	codec, err := NewCodec(r.URL.Query().Get("codec"))
+	if err != nil {
+		logger.Warnf("codec: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := config.Current()
+	if reason := srv.admit(cfg); reason != "" {
+		logger.Warnf("upgrade is rejected, %v", reason)
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	defer srv.release()
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warnf("upgrade: %v", err)
+		return
+	}
+	defer c.Close()
+
+	srv.serve(NewWireWithCodec(c, codec), cfg)
+}
+
+// ServeTCP accepts raw tcp connections on the listener and serves the sessions on them.
+// The frames on raw tcp connections are length-prefixed JSON messages.
+// The connections exceeding server.max_sessions are rejected with the busy response
+// at once without receiving the request.
+// The temporary accept errors are retried with backoff.
+// It returns ErrServerClosed after Shutdown is called.
+func (srv *Server) ServeTCP(l net.Listener) error {
+	srv.mutex.Lock()
+	if srv.shuttingDown {
+		srv.mutex.Unlock()
+		return ErrServerClosed
+	}
+	srv.listeners[l] = struct{}{}
+	srv.mutex.Unlock()
+
+	var delay time.Duration // how long to sleep on accept failure
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-srv.closing:
+				return ErrServerClosed
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// retry the temporary errors, e.g. too many open files, as net/http.Server does
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				if max := 1 * time.Second; delay > max {
+					delay = max
+				}
+				logging.Phase(logging.PhaseServer).Warnf("accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		cfg := config.Current()
+		switch srv.admit(cfg) {
+		case "":
+			go func() {
+				defer srv.release()
+				defer conn.Close()
+				srv.serve(NewTCPWire(conn), cfg)
+			}()
+		case ShuttingDownReason:
+			conn.Close()
+		default:
+			srv.rejectTCP(conn)
+		}
+	}
+}
+
+// rejectTCP sends the busy response without cid on the raw tcp connection rejected and
+// closes it within busyTimeout. The request is not waited for, so that the connections
+// rejected take no session. The connection is closed at once if too many busy responses
+// are being sent.
+func (srv *Server) rejectTCP(conn net.Conn) {
+	select {
+	case srv.busy <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-srv.busy }()
+		defer conn.Close()
+		frame, err := (&Request{}).NewBusyResponse(BusyDetail).Message().BytesOnWire()
+		if err == nil {
+			frame, err = framedCodec{}.Encode(frame)
+		}
+		if err != nil {
+			logging.Conn(conn.RemoteAddr().String()).Errorf("fail to encode busy response, error = %v", err)
+			return
+		}
+		conn.SetDeadline(time.Now().Add(busyTimeout))
+		if _, err = conn.Write(frame); err != nil {
+			return
+		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			// the request sent by the peer is read out, so that the response is not reset by closing
+			tc.CloseWrite()
+			io.Copy(ioutil.Discard, tc)
+		}
+	}()
+}
+
+// admit counts a new connection in. It returns the reason if the connection is rejected
+// since the server is shutting down or the connections reach server.max_sessions.
+func (srv *Server) admit(cfg *config.Config) string {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.shuttingDown {
+		metrics.SessionsRejected.WithLabelValues(metrics.RejectShuttingDown).Inc()
+		return ShuttingDownReason
+	}
+	if max := cfg.Server.MaxSessions; max > 0 && srv.active >= max {
+		metrics.SessionsRejected.WithLabelValues(metrics.RejectBusy).Inc()
+		return BusyDetail
+	}
+	srv.active++
+	srv.conns.Add(1)
+	return ""
+}
+
+// release counts the connection admitted out
+func (srv *Server) release() {
+	srv.mutex.Lock()
+	srv.active--
+	srv.mutex.Unlock()
+	srv.conns.Done()
+}
+
+// admitChannel counts a new session of the channel in, or returns false if the sessions
+// of the channel reach the limit
+func (srv *Server) admitChannel(channel string, cfg *config.Config) bool {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if max := cfg.Server.ChannelLimit(channel); max > 0 && srv.channels[channel] >= max {
+		metrics.SessionsRejected.WithLabelValues(metrics.RejectChannelBusy).Inc()
+		return false
+	}
+	srv.channels[channel]++
+	return true
+}
+
+// releaseChannel counts the session of the channel out
+func (srv *Server) releaseChannel(channel string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.channels[channel]--
+	if srv.channels[channel] == 0 {
+		delete(srv.channels, channel)
+	}
+}
+
+// receive receives the request on the wire. It returns nil after closing the wire if
+// the request is not received.
+func (srv *Server) receive(wire *Wire, cfg *config.Config) *Request {
+	wire.SetLegacy(cfg.Server.LegacyCompatible)
+	go wire.ServerReceive()
+
+	var req *Request
+	var err error
+	var e *Error
+	select {
+	case msg := <-wire.MsgCh:
+		req, err = msg.Request()
+		if err != nil {
+			e = newError(ErrBadRequest, "fail to get request msg, error = %v", err)
+		}
+	case err = <-wire.ErrCh:
+		e = newError(ErrDecode, "fail to get request msg, error = %v", err)
+	case <-time.After(cfg.Server.RequestTimeout):
+		e = newError(ErrTimeout, "fail to get request msg in %v", cfg.Server.RequestTimeout)
+	case <-srv.closing:
+		e = newError(ErrUnavailable, ShuttingDownReason)
+	}
+
+	if e != nil {
+		// no response is sent without the request
+		wire.log(logging.PhaseRequest).Warn(e)
+		wire.SendCloseMessage(e.Code.CloseCode(), e.Code.String())
+		return nil
+	}
+	wire.SetLogger(logging.Session(wire.Logger(), req.CID, req.uid(), req.channel()))
+	return req
+}
+
+// serve receives the request on the wire and runs the session.
+// The session runs with the config in use when it is connected.
+func (srv *Server) serve(wire *Wire, cfg *config.Config) {
+	req := srv.receive(wire, cfg)
+	if req == nil {
+		return
+	}
+
+	channel := req.channel()
+	if !srv.admitChannel(channel, cfg) {
+		sendBusyResponse(wire, req, fmt.Sprintf("channel %q busy, too many sessions", channel))
+		return
+	}
+	defer srv.releaseChannel(channel)
+
+	s := newSession(wire, req, cfg)
+	srv.mutex.Lock()
+	srv.sessions[s] = struct{}{}
+	if srv.stopping {
+		s.stop(newError(ErrUnavailable, ShuttingDownReason))
+	}
+	srv.mutex.Unlock()
+	metrics.SessionsStarted.Inc()
+	metrics.SessionsActive.Inc()
+	defer func() {
+		srv.mutex.Lock()
+		delete(srv.sessions, s)
+		srv.mutex.Unlock()
+		metrics.SessionsActive.Dec()
+		metrics.SessionsEnded.WithLabelValues(s.outcome).Inc()
+	}()
+	s.run()
+}
+
+// Shutdown stops accepting new connections and waits for the active sessions to finish.
+// If the context is done before that, the sessions are stopped with ErrUnavailable and
+// the detail ShuttingDownReason, and the context error is returned
+// after they are closed.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mutex.Lock()
+	if !srv.shuttingDown {
+		srv.shuttingDown = true
+		close(srv.closing)
+		for l := range srv.listeners {
+			l.Close()
+		}
+	}
+	n := len(srv.sessions)
+	srv.mutex.Unlock()
+	logger := logging.Phase(logging.PhaseServer)
+	logger.Infof("server is shutting down, waiting for %v sessions", n)
+
+	drained := make(chan struct{})
+	go func() {
+		srv.conns.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logger.Info("server is shut down, all the sessions are finished")
+		return nil
+	case <-ctx.Done():
+	}
+
+	srv.mutex.Lock()
+	srv.stopping = true
+	n = 0
+	for s := range srv.sessions {
+		if s.stop(newError(ErrUnavailable, ShuttingDownReason)) {
+			n++
+		}
+	}
+	closing := len(srv.sessions) - n
+	srv.mutex.Unlock()
+	logger.Infof("server is shutting down, %v sessions are stopped, %v sessions are closing", n, closing)
+	<-drained
+	return ctx.Err()
+}
@@ -0,0 +1,147 @@
+package hly
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// startSession sends the request of the channel on the wire and waits for the session running
+func startSession(t *testing.T, srv *Server, wire *Wire, cid, channel string) {
+	n := len(srv.Sessions())
+	req := &Request{CID: cid, Rate: "16000", Business: &Business{UID: "u1", Channel: channel}}
+	if err := wire.Send(req.Message()); err != nil {
+		t.Fatal(err)
+	}
+	waitSessions(t, srv, n+1)
+}
+
+// waitSessions waits for the number of the running sessions reaching n
+func waitSessions(t *testing.T, srv *Server, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(srv.Sessions()) < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v sessions are running, want %v", len(srv.Sessions()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// assertBusy checks if the response is the busy one
+func assertBusy(t *testing.T, res *Response) {
+	t.Helper()
+	if res.Result.Code != CodeBusy || res.Result.Error != ErrOverload.String() {
+		t.Errorf("response = %+v, want the busy response", res.Result)
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	cfg := testConfig(t, "http://127.0.0.1:1/asr")
+	cfg.Server.MaxSessions = 1
+	cfg.Server.ChunkTimeout = 10 * time.Second
+	srv := NewServer()
+	url := startServer(t, srv, cfg)
+	startSession(t, srv, dialWire(t, url), "max-1", "03")
+
+	t.Run("websocket", func(t *testing.T) {
+		c, res, err := ws.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			c.Close()
+			t.Fatal("Dial() error = nil, want the upgrade rejected")
+		}
+		if res == nil || res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Dial() response = %v, want status %v", res, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go srv.ServeTCP(l)
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// the busy response is sent without the request
+		wire := NewTCPWire(conn)
+		go wire.ClientReceive()
+		assertBusy(t, receiveResponse(t, wire, nil))
+		if n := len(srv.Sessions()); n != 1 {
+			t.Errorf("Sessions() = %v sessions, want 1", n)
+		}
+	})
+}
+
+func TestMaxSessionsPerChannel(t *testing.T) {
+	cfg := testConfig(t, "http://127.0.0.1:1/asr")
+	cfg.Server.MaxSessionsPerChannel = 1
+	cfg.Server.ChannelMaxSessions = map[string]int{"04": 2}
+	cfg.Server.ChunkTimeout = 10 * time.Second
+	srv := NewServer()
+	url := startServer(t, srv, cfg)
+	startSession(t, srv, dialWire(t, url), "channel-1", "03")
+	startSession(t, srv, dialWire(t, url), "channel-2", "04")
+	startSession(t, srv, dialWire(t, url), "channel-3", "04")
+
+	tests := []struct {
+		channel string
+		busy    bool
+	}{
+		{"03", true},
+		{"04", true},
+		{"05", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			wire := dialWire(t, url)
+			if !tt.busy {
+				startSession(t, srv, wire, "channel-"+tt.channel, tt.channel)
+				return
+			}
+			req := &Request{CID: "channel-" + tt.channel, Rate: "16000", Business: &Business{UID: "u2", Channel: tt.channel}}
+			if err := wire.Send(req.Message()); err != nil {
+				t.Fatal(err)
+			}
+			assertBusy(t, receiveResponse(t, wire, nil))
+		})
+	}
+}
+
+// temporaryError is a temporary net error, e.g. too many open files
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// errorListener is a listener whose Accept returns the errors in order
+type errorListener struct {
+	net.Listener
+	errs     []error
+	accepted int
+}
+
+func (l *errorListener) Accept() (net.Conn, error) {
+	err := l.errs[l.accepted]
+	l.accepted++
+	return nil, err
+}
+
+func TestServeTCPRetriesTemporaryErrors(t *testing.T) {
+	closed := errors.New("use of closed network connection")
+	l := &errorListener{errs: []error{temporaryError{}, temporaryError{}, closed}}
+	if err := NewServer().ServeTCP(l); err != closed {
+		t.Errorf("ServeTCP() error = %v, want %v", err, closed)
+	}
+	if l.accepted != len(l.errs) {
+		t.Errorf("Accept() is called %v times, want %v", l.accepted, len(l.errs))
+	}
+}
@@ -0,0 +1,946 @@
+package hly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/henryleu/go-vad"
+	"github.com/henryleu/vads/hly/asr"
+	"github.com/henryleu/vads/hly/audio"
+	"github.com/henryleu/vads/hly/config"
+	"github.com/henryleu/vads/hly/logging"
+	"github.com/henryleu/vads/hly/metrics"
+	"github.com/henryleu/vads/hly/util"
+	"github.com/sirupsen/logrus"
+)
+
+// eventsCap is the capacity of the detected event channel
+const eventsCap = 16
+
+// detectedEvent is the event emitted by the detector with its offset in the inbound voice stream
+type detectedEvent struct {
+	*vad.Event
+
+	// offset is the time in milliseconds when the event is detected
+	offset int
+
+	// detected is the time when the event is emitted, from which the response latency is observed
+	detected time.Time
+}
+
+// session is a calling session on a websocket connection. It detects only one
+// utterance in single mode, or every utterance until the client ends the session
+// or the call is hung up in multiple mode.
+//
+// The inbound chunks are processed in the goroutine of run() and the detected events
+// are handled concurrently in the goroutine of handleEvents(), so that the clip saving,
+// ASR and response start as soon as the end of voice is detected. The event messages
+// are pushed to the client in the goroutine of run() once the events are detected.
+type session struct {
+	wire     *Wire
+	req      *Request
+	cfg      *config.Config
+	detector *vad.Detector
+
+	// vadConfig is the config of the detector, by which the detector is renewed in multiple mode
+	vadConfig *vad.Config
+
+	// detectorOffset is the offset in milliseconds when the detector starts. The detector caches
+	// all the voice fed to it, so it is renewed after every clip in multiple mode to release the
+	// voice, and the clips are detected in the offsets relative to its start.
+	detectorOffset int
+
+	// engine is the asr engine to recognize the clips
+	engine string
+
+	// voiceTpl is the path template of the clip files
+	voiceTpl string
+
+	// rate is the native sample rate of the voice fed to the detector and ASR
+	rate int
+
+	// resampler resamples the inbound voice to the native rate if the request rate is not native
+	resampler *audio.Resampler
+
+	// assembler assembles the voice in chunks of any size into frames fed to the detector
+	assembler *audio.FrameAssembler
+
+	// frameLen is the bytes of a frame fed to the detector
+	frameLen int
+
+	// chunkNo is the number of the received chunks, which is read by the registry concurrently
+	chunkNo int64
+
+	// state is the state of the session reported by the registry
+	state atomic.Value
+
+	// startTime is the time when the session starts
+	startTime time.Time
+
+	// jitter reorders the chunks by the chunk no
+	jitter *jitterBuffer
+
+	// clipNo is the number of the clips of the detector emitted in multiple mode
+	clipNo int
+
+	// onset detects the beginning of the voice pushed to the client in multiple mode
+	onset *onsetDetector
+
+	// offset is the time in milliseconds of the processed voice stream
+	offset int
+
+	// ended indicates if the client sends the end-of-stream message
+	ended bool
+
+	// cancelled indicates if the client cancels the session
+	cancelled bool
+
+	// stopped is closed when the session is stopped by the server, e.g. on shutting down
+	stopped chan struct{}
+
+	// streamed indicates if the chunk processing is over, after which stop() takes no effect
+	streamed  bool
+	stopMutex sync.Mutex
+
+	// stopErr is the error of the stopped session
+	stopErr *Error
+
+	// terminated indicates if the session is interrupted by stop()
+	terminated bool
+
+	// outcome is the outcome of the session for the metrics, which is error unless it ends well
+	outcome string
+
+	// events is the channel of the events forwarded from the detector in single mode,
+	// or the clipped utterances in multiple mode.
+	events chan *detectedEvent
+
+	// quit is closed by abort() to stop the event handling without response
+	quit chan struct{}
+
+	// ctx is cancelled by abort() to cancel the recognition in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// quitMutex makes sure no response is sent by the event handling after quit is closed
+	quitMutex sync.Mutex
+
+	// done is closed when the event handling is over
+	done chan struct{}
+
+	// the following fields are owned by the event handling and read after done is closed
+
+	// recognized is the number of the clips recognized
+	recognized int
+
+	// hangup indicates if the call is over and the session should be closed
+	hangup bool
+
+	// err is the error occurred in the event handling
+	err *Error
+}
+
+func newSession(wire *Wire, req *Request, cfg *config.Config) *session {
+	voiceDir, _ := getVoiceDir(cfg)
+	s := &session{
+		wire:      wire,
+		req:       req,
+		cfg:       cfg,
+		voiceTpl:  path.Join(voiceDir, "hly-%v-%v.wav"),
+		jitter:    newJitterBuffer(reorderWindow),
+		stopped:   make(chan struct{}),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		startTime: time.Now(),
+		outcome:   metrics.OutcomeError,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.setState(StateStarting)
+	return s
+}
+
+// abort stops the event handling without response and cancels the recognition in progress.
+// It waits for the response being sent, and no response is sent by the event handling after it.
+// It can be called more than once.
+func (s *session) abort() {
+	s.cancel()
+	s.quitMutex.Lock()
+	if !s.quitted() {
+		close(s.quit)
+	}
+	s.quitMutex.Unlock()
+}
+
+// quitted checks if the event handling is aborted
+func (s *session) quitted() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// log returns the logger of the session in the phase, which carries the cid, uid and channel
+func (s *session) log(phase string) *logrus.Entry {
+	return s.wire.log(phase)
+}
+
+// chunks returns the number of the received chunks
+func (s *session) chunks() int {
+	return int(atomic.LoadInt64(&s.chunkNo))
+}
+
+// setState sets the state of the session
+func (s *session) setState(state string) {
+	s.state.Store(state)
+}
+
+// info returns the snapshot of the session for the registry
+func (s *session) info() *SessionInfo {
+	return &SessionInfo{
+		CID:        s.req.CID,
+		Business:   s.req.Business,
+		Mode:       s.req.Mode,
+		StartTime:  s.startTime,
+		Chunks:     s.chunks(),
+		State:      s.state.Load().(string),
+		RemoteAddr: s.wire.RemoteAddr().String(),
+	}
+}
+
+// stop stops the session from another goroutine, and the session responds with the error
+// and closes with its close code. It returns false if the session is not interrupted since
+// its chunk processing is over, e.g. it is recognizing the last utterance in single mode,
+// or it is closing.
+func (s *session) stop(err *Error) bool {
+	s.stopMutex.Lock()
+	defer s.stopMutex.Unlock()
+	if s.streamed {
+		return false
+	}
+	if s.stopErr == nil {
+		s.stopErr = err
+		close(s.stopped)
+	}
+	return true
+}
+
+// run detects and recognizes the utterances in the inbound chunks and sends responses
+func (s *session) run() {
+	defer s.cancel()
+	if err := s.req.Validate(); err != nil {
+		sendErrorResponse(s.wire, s.req, newError(ErrBadRequest, "%v", err))
+		return
+	}
+
+	inRate, _ := s.req.SampleRate()
+	s.rate = getNativeRate(inRate)
+	s.frameLen = s.rate / 1000 * bytesPerSample * frameDuration
+	s.assembler = audio.NewFrameAssembler(s.frameLen)
+	if inRate != s.rate {
+		var err error
+		s.resampler, err = audio.NewResampler(inRate, s.rate)
+		if err != nil {
+			sendErrorResponse(s.wire, s.req, newError(ErrInternal, "fail to resample from %v to %v, error = %v", inRate, s.rate, err))
+			return
+		}
+		s.log(logging.PhaseRequest).Infof("resample from %v to %v", inRate, s.rate)
+	}
+	s.log(logging.PhaseRequest).Infof("sample rate %v, frame length %v", s.rate, s.frameLen)
+
+	profile, err := s.selectProfile()
+	if err != nil {
+		sendErrorResponse(s.wire, s.req, newError(ErrBadRequest, "%v", err))
+		return
+	}
+	s.engine = s.cfg.Engine(profile)
+
+	vadConfig, err := getConfig(s.cfg)
+	if err != nil {
+		sendErrorResponse(s.wire, s.req, newError(ErrInternal, "%v", err))
+		return
+	}
+	vadConfig.Multiple = s.req.Multiple()
+	vadConfig.SampleRate = s.rate
+	if profile != nil {
+		profile.VAD.Apply(vadConfig)
+	}
+	if err := s.req.VAD.Apply(vadConfig); err != nil {
+		sendErrorResponse(s.wire, s.req, newError(ErrBadRequest, "%v", err))
+		return
+	}
+	s.vadConfig = vadConfig
+	if e := s.newDetector(); e != nil {
+		sendErrorResponse(s.wire, s.req, e)
+		return
+	}
+	s.log(logging.PhaseRequest).Debugf("BytesPerFrame %v", s.detector.BytesPerFrame())
+
+	s.events = make(chan *detectedEvent, eventsCap)
+	go s.handleEvents()
+
+	s.setState(StateListening)
+	e := s.processChunks()
+	s.stopMutex.Lock()
+	s.streamed = true
+	s.stopMutex.Unlock()
+	select {
+	case <-s.stopped:
+		// the stop is honored even if the chunk processing is over for another reason
+		s.terminated = true
+	default:
+	}
+	if e != nil || s.terminated || s.cancelled {
+		s.setState(StateClosing)
+	} else {
+		s.setState(StateRecognizing)
+	}
+	if e != nil {
+		s.abort()
+		<-s.done
+		sendErrorResponse(s.wire, s.req, e)
+		return
+	}
+
+	if s.terminated {
+		s.outcome = metrics.OutcomeStopped
+		s.abort()
+		<-s.done
+		s.log(logging.PhaseClose).Infof("session is stopped after %v chunks, reason: %v", s.chunks(), s.stopErr)
+		err = s.wire.Send(s.req.NewErrorResponse(s.stopErr).Message())
+		if err != nil {
+			s.log(logging.PhaseResponse).Errorf("Wire.Send(responseMsg) error = %v", err)
+			return
+		}
+		s.wire.SendCloseMessage(s.stopErr.Code.CloseCode(), s.stopErr.Code.String())
+		return
+	}
+
+	if s.cancelled {
+		s.abort()
+		<-s.done
+		s.sendCancelResponse()
+		return
+	}
+
+	if s.req.Multiple() {
+		s.closeMultiple()
+	}
+	s.awaitEvents()
+	s.setState(StateClosing)
+	if s.cancelled {
+		s.sendCancelResponse()
+		return
+	}
+	if s.err != nil {
+		sendErrorResponse(s.wire, s.req, s.err)
+		return
+	}
+	s.outcome = metrics.OutcomeSuccess
+	if s.ended {
+		s.wire.SendCloseMessage(websocket.CloseNormalClosure, "")
+		return
+	}
+	sendCloseMessage(s.wire, websocket.CloseNormalClosure, "")
+}
+
+// processChunks loops on the inbound chunks and feeds them to the detector frame by frame
+// until the detector stops in single mode, the event handling is over, or the client
+// ends or cancels the session. It returns the error if the session fails.
+func (s *session) processChunks() *Error {
+	for {
+		select {
+		case msg := <-s.wire.MsgCh:
+			switch msg.Type {
+			case EndOfStreamType:
+				eos, _ := msg.EndOfStream()
+				if s.req.CID != eos.CID {
+					return newError(ErrBadRequest, "fail to end stream, want cid %v, got %v", s.req.CID, eos.CID)
+				}
+				s.log(logging.PhaseStream).Infof("session is ended by client after %v chunks", s.chunks())
+				s.ended = true
+				return s.finalize()
+			case CancelType:
+				cnl, _ := msg.Cancel()
+				if s.req.CID != cnl.CID {
+					return newError(ErrBadRequest, "fail to cancel session, want cid %v, got %v", s.req.CID, cnl.CID)
+				}
+				s.log(logging.PhaseStream).Infof("session is cancelled by client after %v chunks, reason: %v", s.chunks(), cnl.Reason)
+				s.cancelled = true
+				return nil
+			}
+			more, e := s.processChunk(msg)
+			if !more || e != nil {
+				return e
+			}
+			// go on looping more chunks
+		case err := <-s.wire.ErrCh:
+			return newError(ErrDecode, "fail to get chunk msg, error = %v", err)
+		case <-s.done:
+			// the call is hung up or the event handling fails
+			return nil
+		case <-s.stopped:
+			return nil
+		case <-s.wire.Closed:
+			if len(s.wire.MsgCh) > 0 {
+				// process the messages received before closing
+				continue
+			}
+			s.log(logging.PhaseStream).Infof("session is closed by client after %v chunks", s.chunks())
+			return s.finalize()
+		case <-time.After(s.cfg.Server.ChunkTimeout):
+			return s.finalize()
+		}
+	} // end loop chunk
+}
+
+// awaitEvents waits for the event handling to be over after the chunk processing. The client
+// may still cancel the recognition in progress or end the stream meanwhile, and the chunks
+// received after the detector stops are discarded.
+func (s *session) awaitEvents() {
+	closed := s.wire.Closed
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-s.wire.MsgCh:
+			switch msg.Type {
+			case EndOfStreamType:
+				s.ended = true
+			case CancelType:
+				cnl, _ := msg.Cancel()
+				if s.req.CID != cnl.CID {
+					s.log(logging.PhaseStream).Warnf("fail to cancel session, want cid %v, got %v", s.req.CID, cnl.CID)
+					continue
+				}
+				s.log(logging.PhaseStream).Infof("session is cancelled by client during recognition, reason: %v", cnl.Reason)
+				s.setState(StateClosing)
+				s.abort()
+				<-s.done
+				// the response of the single mode may be sent before the abort
+				s.cancelled = s.req.Multiple() || s.recognized == 0
+				return
+			}
+		case <-s.wire.ErrCh:
+			// the messages which fail to be decoded are discarded as well
+		case <-closed:
+			// the client is gone, and the recognition goes on for the metrics and the logs
+			closed = nil
+		}
+	}
+}
+
+// sendCancelResponse sends the cancel response and closes normally
+func (s *session) sendCancelResponse() {
+	s.outcome = metrics.OutcomeCancelled
+	err := s.wire.Send(s.req.NewCancelResponse().Message())
+	if err != nil {
+		s.log(logging.PhaseResponse).Errorf("Wire.Send(responseMsg) error = %v", err)
+		return
+	}
+	s.wire.SendCloseMessage(websocket.CloseNormalClosure, CancelledDetail)
+}
+
+// decode decodes the G.711 voice in the chunk to 16 bits linear pcm
+func (s *session) decode(data []byte) []byte {
+	switch s.req.Encoding {
+	case EncodingALaw:
+		return audio.DecodeALaw(data)
+	case EncodingULaw:
+		return audio.DecodeULaw(data)
+	default:
+		return data
+	}
+}
+
+// processChunk validates the chunk and feeds it to the detector frame by frame.
+// It returns false if no more chunk is needed, or the error if the chunk is illegal.
+func (s *session) processChunk(msg Message) (more bool, e *Error) {
+	chunk, err := msg.Chunk()
+	if err != nil {
+		return false, newError(ErrBadRequest, "fail to get chunk msg, error = %v", err)
+	}
+	if msg.Type == AudioType {
+		// binary audio frame carries no cid and its audio is not encoded
+		if !s.req.Binary() {
+			return false, newError(ErrBadRequest, "fail to get audio msg, transfer is %q but not %q", s.req.Transfer, TransferBinary)
+		}
+	} else {
+		err = chunk.DecodeAudio()
+		if err != nil {
+			return false, newError(ErrDecode, "fail to decode chunk audio, error = %v", err)
+		}
+		if s.req.CID != chunk.CID {
+			return false, newError(ErrBadRequest, "fail to get chunk msg, want cid %v, got %v", s.req.CID, chunk.CID)
+		}
+	}
+	atomic.AddInt64(&s.chunkNo, 1)
+	metrics.ChunksReceived.Inc()
+	if chunk.NO-s.jitter.Next() > maxChunkGap {
+		return false, newError(ErrChunkSequence, "fail to validate chunk no, want %d, got %d", s.jitter.Next(), chunk.NO)
+	}
+	if chunk.NO != s.jitter.Next() {
+		s.log(logging.PhaseStream).Debugf("chunk NO[%v] is out of order, want %v", chunk.NO, s.jitter.Next())
+	}
+	// the chunks are reordered in the jitter buffer, and the missing ones are filled with silence
+	for _, data := range s.jitter.Put(chunk.NO, s.decode(chunk.Data)) {
+		more, e := s.feed(data)
+		if e != nil {
+			e.Detail = fmt.Sprintf("fail to process frame in chunk NO[%v], error = %v", chunk.NO, e.Detail)
+			return false, e
+		}
+		if !more {
+			s.log(logging.PhaseVAD).Infof("detector is stopped after %v chunks", s.chunks())
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// feed feeds the linear pcm in a chunk to the detector frame by frame.
+// It returns false if no more chunk is needed, or the error if the detector fails.
+func (s *session) feed(data []byte) (more bool, e *Error) {
+	if s.resampler != nil {
+		data = s.resampler.Process(data)
+	}
+	// the chunk may be in any size, and the bytes not enough for a frame are carried over to the next chunk
+	s.assembler.Write(data)
+	for {
+		frame, ok := s.assembler.Next() // a slice with 320 bytes in 8k
+		if !ok {
+			return true, nil
+		}
+		more, e := s.processFrame(frame)
+		if !more || e != nil {
+			return more, e
+		}
+	} // end loop frame
+}
+
+// newDetector creates the detector by the vad config, and the onset detector as well if the
+// voice begin events are pushed in multiple mode, so that both start with the same vad state.
+func (s *session) newDetector() *Error {
+	d := s.vadConfig.NewDetector()
+	d.SampleRate = s.rate
+	d.BytesPerSample = bytesPerSample
+	d.FrameDuration = frameDuration
+	if err := d.Init(); err != nil {
+		return newError(ErrInternal, "Detector.Init() error = %v", err)
+	}
+	s.detector = d
+	s.clipNo = 0
+	if s.req.Multiple() && s.req.Events {
+		o, err := newOnsetDetector(s.vadConfig, s.rate, frameDuration)
+		if err != nil {
+			return newError(ErrInternal, "fail to create onset detector, error = %v", err)
+		}
+		s.onset = o
+	}
+	return nil
+}
+
+// processFrame feeds a frame to the detector and emits the detected events.
+// It returns false if no more frame is needed, or the error if the detector fails.
+func (s *session) processFrame(frame []byte) (more bool, e *Error) {
+	var begin bool
+	if s.onset != nil {
+		var err error
+		begin, err = s.onset.Process(frame)
+		if err != nil {
+			return false, newError(ErrInternal, "%v", err)
+		}
+	}
+	err := s.detector.Process(frame)
+	if err != nil {
+		return false, newError(ErrInternal, "%v", err)
+	}
+	metrics.FramesProcessed.Inc()
+	s.offset += frameDuration
+	if s.req.Multiple() {
+		if begin && !s.emit(&detectedEvent{Event: &vad.Event{Type: vad.EventVoiceBegin}, offset: s.offset}) {
+			return false, nil
+		}
+		clipNo := s.clipNo
+		more := s.emitClips(s.detector.Clips)
+		if s.clipNo > clipNo {
+			s.setState(StateListening)
+			// the detector is in inactivity right after the clip, so a new one takes over from here
+			s.detectorOffset = s.offset
+			if e := s.newDetector(); e != nil {
+				return false, e
+			}
+		}
+		return more, nil
+	}
+	s.forwardEvents()
+	return s.detector.Working(), nil
+}
+
+// finalize feeds the rest voice and forces the detector to end speech in single mode.
+// In multiple mode, the speech in progress is taken by Detector.GetClips() on closing the session.
+// It returns the error if the detector fails, and the session ends with it, since no event
+// may be emitted then.
+func (s *session) finalize() *Error {
+	// the chunks waiting for the missing ones are fed in order
+	for _, data := range s.jitter.Flush() {
+		if !s.detector.Working() {
+			break
+		}
+		more, e := s.feed(data)
+		if e != nil {
+			e.Detail = fmt.Sprintf("fail to process the pending chunks, error = %v", e.Detail)
+			return e
+		}
+		if !more {
+			return nil
+		}
+	}
+	if stats := s.jitter.stats.String(); stats != "" {
+		s.log(logging.PhaseStream).Infof("session received %v chunks, %v", s.chunks(), stats)
+	}
+
+	// the rest voice not enough for a frame is padded with silence
+	if frame, ok := s.assembler.Flush(); ok && s.detector.Working() {
+		more, e := s.processFrame(frame)
+		if e != nil {
+			e.Detail = fmt.Sprintf("fail to process the last frame, error = %v", e.Detail)
+			return e
+		}
+		if !more {
+			return nil
+		}
+	}
+	if !s.req.Multiple() {
+		s.detector.Finalize()
+		s.forwardEvents()
+	}
+	return nil
+}
+
+// forwardEvents forwards the events emitted by the detector in single mode.
+// The detector emits at most 2 events during the session, so its buffered channel
+// never blocks the detector as long as the events are forwarded after processing.
+func (s *session) forwardEvents() {
+	for {
+		select {
+		case e := <-s.detector.Events:
+			s.emit(&detectedEvent{Event: e, offset: s.offset})
+		default:
+			return
+		}
+	}
+}
+
+// emitClips emits the newly detected clips as voice end events in multiple mode.
+// It returns false if the event handling is over.
+func (s *session) emitClips(clips []*vad.Clip) bool {
+	for s.clipNo < len(clips) {
+		if s.onset != nil {
+			s.onset.End()
+		}
+		clip := clips[s.clipNo]
+		clip.Start += s.detectorOffset
+		e := &detectedEvent{
+			Event:  &vad.Event{Type: vad.EventVoiceEnd, Clip: clip},
+			offset: s.offset,
+		}
+		s.clipNo++
+		if !s.emit(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// emit pushes the event message to the client and queues the event for handling.
+// It returns false if the event handling is over.
+func (s *session) emit(e *detectedEvent) bool {
+	e.detected = time.Now()
+	metrics.VADEvents.WithLabelValues(eventName(e.Type)).Inc()
+	switch {
+	case e.Type == vad.EventVoiceBegin:
+		s.setState(StateSpeaking)
+	case !s.req.Multiple():
+		s.setState(StateRecognizing)
+	}
+	if s.req.Events {
+		s.pushEvent(e)
+	}
+	select {
+	case s.events <- e:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// pushEvent sends the event message to the client
+func (s *session) pushEvent(e *detectedEvent) {
+	name := eventName(e.Type)
+	if name == "" {
+		return
+	}
+	evt := &Event{
+		CID:    s.req.CID,
+		Event:  name,
+		Offset: e.offset,
+	}
+	err := s.wire.Send(evt.Message())
+	if err != nil {
+		s.log(logging.PhaseResponse).Errorf("Wire.Send(eventMsg) error = %v", err)
+	}
+}
+
+// eventName returns the name of the detector event type in the event message, or empty if unknown
+func eventName(t vad.EventType) string {
+	switch t {
+	case vad.EventVoiceBegin:
+		return EventVoiceBegin
+	case vad.EventVoiceEnd:
+		return EventVoiceEnd
+	case vad.EventNoinput:
+		return EventNoinput
+	default:
+		return ""
+	}
+}
+
+// closeMultiple emits the speech which is still in progress in multiple mode
+// and then ends the event handling.
+func (s *session) closeMultiple() {
+	select {
+	case <-s.wire.Closed:
+		// the client is gone, no one is waiting for the rest
+		s.abort()
+		return
+	default:
+	}
+	if s.emitClips(s.detector.GetClips()) {
+		close(s.events)
+	}
+}
+
+// handleEvents handles the detected events until the response of the single mode is sent,
+// or the call is hung up or all the utterances are recognized in multiple mode.
+func (s *session) handleEvents() {
+	defer close(s.done)
+	for {
+		var e *detectedEvent
+		var ok bool
+		select {
+		case e, ok = <-s.events:
+			if !ok {
+				return
+			}
+		case <-s.quit:
+			return
+		}
+		select {
+		case <-s.quit:
+			return
+		default:
+		}
+
+		switch e.Type {
+		case vad.EventVoiceBegin:
+			// 根据被叫获取当前流程信息以及场景信息
+			//postData := map[string]interface{}{
+			//	"mobile": req.Business.Called,
+			//}
+			//if flowInfo, err := util.FlowInfoByNumber(postData); err == nil {
+			//	infoMsg = flowInfo.(map[string]interface{})
+			//}
+		case vad.EventVoiceEnd:
+			var voicePath string
+			if s.req.Multiple() {
+				voicePath, s.err = s.saveClip(e.Clip)
+			} else {
+				voicePath, s.err = s.saveSingleClips()
+			}
+			if s.err != nil {
+				return
+			}
+			s.respond(voicePath, e.detected)
+			if s.err != nil || !s.req.Multiple() || s.hangup {
+				return
+			}
+		case vad.EventNoinput:
+			s.err = newError(ErrNoinput, "fail to detect speech before the noinput timeout")
+			return
+		default:
+			s.log(logging.PhaseVAD).Warnf("illegal event type %v", e.Type)
+		}
+	}
+}
+
+// saveClip saves the utterance clip in multiple mode and returns the path of the clip file,
+// or the error.
+func (s *session) saveClip(clip *vad.Clip) (voicePath string, e *Error) {
+	voicePath = fmt.Sprintf(s.voiceTpl, s.req.CID, fmt.Sprintf("%v-%03d", time.Now().Format("20060102150405"), s.recognized+1))
+	err := clip.SaveToFile(voicePath)
+	if err != nil {
+		e = newError(ErrInternal, "fail to save clip, error = %v", err)
+		s.log(logging.PhaseVAD).Errorf("fail to save clip, error = %v", err)
+		return
+	}
+	metrics.ClipDuration.Observe(clip.Duration.Seconds())
+	s.log(logging.PhaseVAD).Infof("succeed to save clip %v (start %vms, duration %v)", voicePath, clip.Start, clip.Duration)
+	return
+}
+
+// saveSingleClips saves the detected clip and the total clip in single mode.
+// It returns the path of the clip file to recognize, or the error.
+func (s *session) saveSingleClips() (voicePath string, e *Error) {
+	detector := s.detector
+	//f, err := ioutil.TempFile("", fmt.Sprintf("clip-%v-*.wav", req.CID))
+	t := time.Now()
+
+	// detected clip
+	voicePath = fmt.Sprintf(s.voiceTpl, s.req.CID, t.Format("20060102150405001"))
+	f, err := os.Create(voicePath)
+	if err != nil {
+		e = newError(ErrInternal, "fail to save clip, error = %v", err)
+		s.log(logging.PhaseVAD).Errorf("fail to save clip, error = %v", err)
+		return
+	}
+	detector.Clip.SaveToWriter(f)
+	metrics.ClipDuration.Observe(detector.Clip.Duration.Seconds())
+	logger := s.log(logging.PhaseVAD)
+	if logging.IsDebug() {
+		detector.Clip.PrintDetail()
+	}
+	logger.Debugf("detector.SpeechTimeout %v", detector.SpeechTimeout)
+	logger.Debugf("detector.SilenceTimeout %v", detector.SilenceTimeout)
+	logger.Debugf("detector.BytesPerFrame %v", detector.BytesPerFrame())
+	logger.Debugf("clip degest %v", detector.Clip.GenerateDigest())
+
+	// total clip
+	voicePath = fmt.Sprintf(s.voiceTpl, s.req.CID, t.Format("20060102150405002"))
+	f, err = os.Create(voicePath)
+	if err != nil {
+		e = newError(ErrInternal, "fail to save clip, error = %v", err)
+		s.log(logging.PhaseVAD).Errorf("fail to save clip, error = %v", err)
+		return
+	}
+	tc := detector.GetTotalClip()
+	tc.SaveToWriter(f)
+	if logging.IsDebug() {
+		tc.PrintDetail()
+	}
+	logger.Debugf("total clip degest %v", tc.GenerateDigest())
+
+	logger.Infof("succeed to save clip %v", f.Name())
+	return
+}
+
+// respond recognizes the clip file and sends the response, whose latency is observed from
+// the time when the end of speech is detected. The session is marked as hung up if the
+// response says to end the call, and fails with ErrASR if the recognition fails.
+func (s *session) respond(voicePath string, detected time.Time) {
+	// todo asr and nlp here
+	//asrText := util.AsrClient(voicePath)
+	//postData := map[string]interface{}{
+	//	"user_id":   req.CID,
+	//	"token":     infoMsg["flow_token"],
+	//	"robot_id":  infoMsg["robot_id"],
+	//	"parameter": infoMsg["parameter"],
+	//	"input":     asrText,
+	//}
+	//if flowReturn, err := util.FlowUtilSay(postData); err == nil {
+	//	flowData := flowReturn.(map[string]interface{})
+	//	output_command := strings.Replace(flowData["output_command"].(string), "\r\n", "", -1)
+	//	arr := strings.Split(output_command, ".")
+	//	recog := Recognition{
+	//		AnswerText: flowData["user_label"].(string),
+	//		AudioText:  asrText,
+	//		AudioNum:   arr[0],
+	//	}
+	//	var status int
+	//	if flowData["flow_end"] == true {
+	//		status = 1
+	//	} else {
+	//		status = 0
+	//	}
+	//	msg := req.NewSuccessResponse(status, &recog)
+	//	...
+	//} else {
+	//	sendErrorResponse(wire, req, errMsg)
+	//	return
+	//}
+	//  todo 返回语音识别结果
+	result, e := s.recognize(voicePath)
+	s.quitMutex.Lock()
+	defer s.quitMutex.Unlock()
+	if s.quitted() {
+		// the session is cancelled or stopped during the recognition
+		s.log(logging.PhaseASR).Infof("recognition is aborted, no response is sent")
+		return
+	}
+	if e != nil {
+		s.err = e
+		return
+	}
+	recog := Recognition{
+		AnswerText: "",
+		AudioText:  result.Text,
+		AudioNum:   "",
+	}
+	s.recognized++
+	res := s.req.NewSuccessResponse(0, &recog)
+	if stats := s.jitter.stats.String(); stats != "" {
+		res.Result.Detail = fmt.Sprintf("%v (%v)", res.Result.Detail, stats)
+	}
+	if data, err := json.Marshal(res); err == nil {
+		s.log(logging.PhaseResponse).Infof("response: %s", data)
+	}
+	err := s.wire.Send(res.Message())
+	if err != nil {
+		s.log(logging.PhaseResponse).Errorf("Wire.Send(responseMsg) error = %v", err)
+		s.hangup = true
+		return
+	}
+	metrics.ResponseLatency.Observe(time.Since(detected).Seconds())
+	if res.Result.Return.Control.Status == 1 {
+		s.log(logging.PhaseClose).Infof("session is hung up after %v clips", s.recognized)
+		s.hangup = true
+	}
+}
+
+// selectProfile returns the profile specified by the request, or selected by the rules
+// on the business info. It returns nil if no profile is specified or matched.
+func (s *session) selectProfile() (*config.Profile, error) {
+	name := s.req.Profile
+	if name == "" && s.req.Business != nil {
+		b := s.req.Business
+		name = s.cfg.SelectProfile(b.Channel, b.Province, b.Called)
+	}
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := s.cfg.Profile(name)
+	if !ok {
+		return nil, fmt.Errorf("request error - profile %q is not defined", name)
+	}
+	s.log(logging.PhaseRequest).Infof("profile %v is selected", name)
+	return profile, nil
+}
+
+// recognize recognizes the clip file by the asr engine of the session within asr.timeout
+func (s *session) recognize(voicePath string) (*asr.Result, *Error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.ASR.Timeout)
+	defer cancel()
+	res, err := util.RecognizeFile(ctx, s.engine, voicePath, s.rate, &s.cfg.ASR)
+	if err != nil {
+		return nil, newError(ErrASR, "fail to recognize by %v, error = %v", s.engine, err)
+	}
+	s.log(logging.PhaseASR).Infof("asr result by %v: %v", s.engine, res.Text)
+	return res, nil
+}
@@ -0,0 +1,164 @@
+package hly
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/henryleu/vads/hly/config"
+)
+
+// testVoiceFile is a wave file with a few utterances in 16k
+const testVoiceFile = "../testcases/data/16khz-16bits-1.wav"
+
+// testChunkLen is the bytes of a chunk, which is 80ms of voice in 16k
+const testChunkLen = 2560
+
+// testConfig returns the config using the http asr service at the url, whose clip files
+// are saved in a temp dir removed after the test
+func testConfig(t *testing.T, asrURL string) *config.Config {
+	dir, err := ioutil.TempDir("", "vads-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	cfg := config.Default()
+	cfg.Server.VoiceDir = dir
+	cfg.Server.AdminListen = ""
+	cfg.ASR.Engine = config.EngineHTTP
+	cfg.ASR.HTTP.URL = asrURL
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// startServer serves the websocket sessions of the server with the config in use
+func startServer(t *testing.T, srv *Server, cfg *config.Config) string {
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(config.Default()) })
+	ts := httptest.NewServer(http.HandlerFunc(srv.HandleMRCP))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+// dialWire connects to the server and returns the client wire talking in envelopes
+func dialWire(t *testing.T, url string) *Wire {
+	c, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial(%v) error = %v", url, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	wire := NewWire(c)
+	wire.SetEnvelope(true)
+	go wire.ClientReceive()
+	return wire
+}
+
+// testVoice returns the linear pcm in the test voice file
+func testVoice(t *testing.T) []byte {
+	data, err := ioutil.ReadFile(testVoiceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data[44:]
+}
+
+// streamVoice sends the voice in chunks until it is over or stop is closed
+func streamVoice(wire *Wire, cid string, voice []byte, stop <-chan struct{}) {
+	for no := 1; len(voice) >= testChunkLen; no++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		chunk := &Chunk{CID: cid, NO: no, Data: voice[:testChunkLen]}
+		chunk.EncodeAudio()
+		if wire.Send(chunk.Message()) != nil {
+			return
+		}
+		voice = voice[testChunkLen:]
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receiveResponse returns the first response on the wire, or nil if none is received in time.
+// voiceEnd is closed once the voice end event is received if it is not nil.
+func receiveResponse(t *testing.T, wire *Wire, voiceEnd chan struct{}) *Response {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg := <-wire.MsgCh:
+			switch msg.Type {
+			case EventType:
+				evt, _ := msg.Event()
+				if evt.Event == EventVoiceEnd && voiceEnd != nil {
+					close(voiceEnd)
+					voiceEnd = nil
+				}
+			case ResponseType:
+				res, err := msg.Response()
+				if err != nil {
+					t.Fatalf("Message.Response() error = %v", err)
+				}
+				return res
+			}
+		case <-wire.Closed:
+			t.Fatal("connection is closed before the response")
+		case <-timeout:
+			t.Fatal("no response is received in time")
+		}
+	}
+}
+
+func TestCancelDuringRecognition(t *testing.T) {
+	recognizing := make(chan struct{}, 1)
+	aborted := make(chan struct{}, 1)
+	asrServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the cancellation of the client is noticed after the body is read
+		ioutil.ReadAll(r.Body)
+		recognizing <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(5 * time.Second):
+			w.Write([]byte(`{"text":"too late"}`))
+		}
+	}))
+	defer asrServer.Close()
+
+	url := startServer(t, NewServer(), testConfig(t, asrServer.URL))
+	wire := dialWire(t, url)
+	req := &Request{CID: "cancel-1", Rate: "16000", Events: true, Business: &Business{UID: "u1", Channel: "03"}}
+	if err := wire.Send(req.Message()); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go streamVoice(wire, req.CID, testVoice(t), stop)
+
+	voiceEnd := make(chan struct{})
+	go func() {
+		<-voiceEnd
+		select {
+		case <-recognizing:
+		case <-time.After(5 * time.Second):
+			return
+		}
+		wire.Send((&Cancel{CID: req.CID, Reason: "hangup"}).Message())
+	}()
+	res := receiveResponse(t, wire, voiceEnd)
+	if res.Result.Code != 0 || res.Result.Detail != CancelledDetail {
+		t.Errorf("response = %+v, want the cancel response", res.Result)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Error("recognition in progress is not aborted")
+	}
+}
@@ -0,0 +1,117 @@
+package hly
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// transport is the connection under the wire which reads and writes frames
+type transport interface {
+	// ReadMessage reads a frame and returns the message in it
+	ReadMessage() ([]byte, error)
+
+	// WriteMessage writes the message in a frame
+	WriteMessage(msg []byte) error
+
+	// WriteClose tells the peer that the connection is closing with the code and reason
+	WriteClose(code int, reason string) error
+
+	// Close closes the underlying connection
+	Close() error
+
+	// RemoteAddr returns the remote network address
+	RemoteAddr() net.Addr
+}
+
+// wsTransport sends messages in websocket binary frames encoded by the codec
+type wsTransport struct {
+	conn  *ws.Conn
+	codec Codec
+}
+
+func (t *wsTransport) ReadMessage() ([]byte, error) {
+	_, frame, err := t.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return t.codec.Decode(frame)
+}
+
+func (t *wsTransport) WriteMessage(msg []byte) error {
+	frame, err := t.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	wc, err := t.conn.NextWriter(ws.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if _, err = wc.Write(frame); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+func (t *wsTransport) WriteClose(code int, reason string) error {
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(code, reason))
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *wsTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// tcpTransport sends messages in length-prefixed frames on a raw tcp connection
+type tcpTransport struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	codec  Codec
+}
+
+func newTCPTransport(conn net.Conn) *tcpTransport {
+	return &tcpTransport{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		codec:  framedCodec{},
+	}
+}
+
+func (t *tcpTransport) ReadMessage() ([]byte, error) {
+	return ReadFramed(t.reader)
+}
+
+func (t *tcpTransport) WriteMessage(msg []byte) error {
+	frame, err := t.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_, err = t.conn.Write(frame)
+	return err
+}
+
+// WriteClose half-closes the connection since there is no close frame on raw tcp,
+// and the peer reads EOF after all the messages sent.
+func (t *tcpTransport) WriteClose(code int, reason string) error {
+	tc, ok := t.conn.(*net.TCPConn)
+	if !ok {
+		return fmt.Errorf("transport error - fail to half-close non-tcp connection %v", t.conn.RemoteAddr())
+	}
+	return tc.CloseWrite()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *tcpTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
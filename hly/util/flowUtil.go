@@ -2,23 +2,29 @@ package util
 
 import (
 	"encoding/json"
-	"fmt"
+	"github.com/henryleu/vads/hly/config"
+	"github.com/henryleu/vads/hly/logging"
 	"github.com/kirinlabs/HttpRequest"
-	"log"
+	"github.com/sirupsen/logrus"
 )
 
 //const FlowSayDoUrl = "http://flowtest.aimango.net:5080/robot/say.do"
 //const FlowClosedUrl = "http://flowtest.aimango.net:5080/robot/closed.do"
 //const FlowInfoUrl = "http://114.116.103.13:8088/api/hly/flow/get"
 
-const FlowSayDoUrl = "http://114.116.238.23/robot/say.do"
-const FlowClosedUrl = "http://114.116.238.23/robot/closed.do"
-const FlowInfoUrl = "http://call-aid.aimango.net/api/hly/flow/get"
+// the flow urls are configured in the flow section of the config
 
 //const FlowTokenInfo = "Token 21c7d084b200a17c9641c83d4697fde9"
 //const FlowTokenInfo = "Token 814069ed3a6eadd19c1dad445a8c8115     "
 
+// FlowUtilSay posts the input to the flow and returns the answer, and logs with the empty session fields
 func FlowUtilSay(paramMap interface{}) (returnMap interface{}, err error) {
+	return FlowUtilSayWithLogger(logging.Conn(""), paramMap)
+}
+
+// FlowUtilSayWithLogger posts the input to the flow and returns the answer, and logs with
+// the logger of the session, e.g. Wire.Logger()
+func FlowUtilSayWithLogger(logger *logrus.Entry, paramMap interface{}) (returnMap interface{}, err error) {
 	/**
 	 ** 开启流程会话接口
 	 * 设置HTTP REST POST请求
@@ -26,13 +32,14 @@ func FlowUtilSay(paramMap interface{}) (returnMap interface{}, err error) {
 	 * 2.调用/robot/say.do接口开启对话
 	 * 4.设置必须请求参数：user_id，robot_id，input，token
 	 */
-	log.Print(paramMap.(map[string]interface{}))
+	logger = logger.WithField(logging.FieldPhase, logging.PhaseFlow)
+	logger.Debugf("flow say: %v", paramMap.(map[string]interface{}))
 	req := HttpRequest.NewRequest()
 	//req.SetHeaders(map[string]string{"Authorization": FlowTokenInfo})
 	req.SetHeaders(map[string]string{"Content-Type": "application/json"})
-	resp, err := req.Post(FlowSayDoUrl, paramMap)
+	resp, err := req.Post(config.Current().Flow.SayURL, paramMap)
 	if err != nil {
-		fmt.Printf(" post err:%s", err)
+		logger.Errorf("flow-say post err: %v", err)
 	}
 	var dat map[string]interface{}
 	body, err := resp.Body()
@@ -44,7 +51,13 @@ func FlowUtilSay(paramMap interface{}) (returnMap interface{}, err error) {
 	return
 }
 
+// FlowUtilClosed closes the flow, and logs with the empty session fields
 func FlowUtilClosed(paramMap interface{}) {
+	FlowUtilClosedWithLogger(logging.Conn(""), paramMap)
+}
+
+// FlowUtilClosedWithLogger closes the flow, and logs with the logger of the session
+func FlowUtilClosedWithLogger(logger *logrus.Entry, paramMap interface{}) {
 	/**
 	 ** 关闭流程会话接口
 	 * 设置HTTP REST POST请求
@@ -52,20 +65,27 @@ func FlowUtilClosed(paramMap interface{}) {
 	 * 2.调用/robot/closed.do接口开启对话
 	 * 4.设置必须请求参数：user_id，robot_id，input，token
 	 */
+	logger = logger.WithField(logging.FieldPhase, logging.PhaseFlow)
 	req := HttpRequest.NewRequest()
 	//req.SetHeaders(map[string]string{"Authorization": FlowTokenInfo})
 	req.SetHeaders(map[string]string{"Content-Type": "application/json"})
-	resp, err := req.Post(FlowClosedUrl, paramMap)
+	resp, err := req.Post(config.Current().Flow.ClosedURL, paramMap)
 	if err != nil {
-		fmt.Printf("flow-closed post err:%s", err)
+		logger.Errorf("flow-closed post err: %v", err)
 	}
 	var dat map[string]interface{}
 	body, err := resp.Body()
 	_ = json.Unmarshal(body, &dat)
-	log.Printf("flow return :%s \n", dat)
+	logger.Infof("flow return: %v", dat)
 }
 
+// FlowInfoByNumber gets the flow info by the called number, and logs with the empty session fields
 func FlowInfoByNumber(paramMap interface{}) (returnMap interface{}, err error) {
+	return FlowInfoByNumberWithLogger(logging.Conn(""), paramMap)
+}
+
+// FlowInfoByNumberWithLogger gets the flow info by the called number, and logs with the logger of the session
+func FlowInfoByNumberWithLogger(logger *logrus.Entry, paramMap interface{}) (returnMap interface{}, err error) {
 	/**
 	** 流程会话接口
 	 * 设置HTTP REST POST请求
@@ -76,16 +96,17 @@ func FlowInfoByNumber(paramMap interface{}) (returnMap interface{}, err error) {
 	//postData := map[string]interface{}{
 	//	"mobile":  "18322693235",
 	//}
+	logger = logger.WithField(logging.FieldPhase, logging.PhaseFlow)
 	req := HttpRequest.NewRequest()
 	req.SetHeaders(map[string]string{"Content-Type": "application/json"})
-	resp, err := req.Post(FlowInfoUrl, paramMap)
+	resp, err := req.Post(config.Current().Flow.InfoURL, paramMap)
 	if err != nil {
-		fmt.Printf("asr post err:%s", err)
+		logger.Errorf("flow-info post err: %v", err)
 	}
 	var dat map[string]interface{}
 	body, err := resp.Body()
 	_ = json.Unmarshal(body, &dat)
-	log.Printf("web-server-bak return :%v\n", dat)
+	logger.Infof("flow-info return: %v", dat)
 	if _, ok := dat["data"]; ok {
 		returnMap = dat["data"].(map[string]interface{})
 		return
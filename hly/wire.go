@@ -2,15 +2,16 @@ package hly
 
 import (
 	"fmt"
-	"log"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ws "github.com/gorilla/websocket"
+	"github.com/henryleu/vads/hly/logging"
+	"github.com/sirupsen/logrus"
 )
 
-const debugMessage = false
-
 // Time allowed to write a message to the peer.
 const writeWait = 10 * time.Second
 
@@ -19,39 +20,85 @@ const closeGracePeriod = 2 * time.Second
 
 // Wire wraps
 type Wire struct {
-	MsgCh  chan Message
-	ErrCh  chan error
-	conn   *ws.Conn
+	MsgCh chan Message
+	ErrCh chan error
+	// Closed is closed when the peer closes the connection and no more message is received
+	Closed chan struct{}
+	conn   transport
 	closed bool
+	// enveloped indicates if the messages are sent in envelopes
+	enveloped bool
+	// legacy indicates if the un-enveloped messages of legacy clients are accepted by ServerReceive
+	legacy bool
 	mutex  sync.Mutex
+
+	// logger is the logger of the connection, which carries the session fields once the request is received
+	logger atomic.Value
 }
 
 // NewWire creates wire between game server-bak and team client.
 func NewWire(conn *ws.Conn) *Wire {
-	return &Wire{
-		MsgCh: make(chan Message, 2),
-		ErrCh: make(chan error, 10),
-		conn:  conn,
+	return newWire(&wsTransport{conn: conn, codec: plainCodec{}})
+}
+
+// NewWireWithCodec creates wire on the websocket connection whose frames are encoded by the codec.
+func NewWireWithCodec(conn *ws.Conn, codec Codec) *Wire {
+	return newWire(&wsTransport{conn: conn, codec: codec})
+}
+
+// NewTCPWire creates wire on the raw tcp connection with length-prefixed frames.
+func NewTCPWire(conn net.Conn) *Wire {
+	return newWire(newTCPTransport(conn))
+}
+
+func newWire(conn transport) *Wire {
+	w := &Wire{
+		MsgCh:  make(chan Message, 2),
+		ErrCh:  make(chan error, 10),
+		Closed: make(chan struct{}),
+		conn:   conn,
+		legacy: true,
 	}
+	w.SetLogger(logging.Conn(conn.RemoteAddr().String()))
+	return w
+}
+
+// SetLogger sets the logger of the connection, e.g. the one with the session fields
+func (w *Wire) SetLogger(logger *logrus.Entry) {
+	w.logger.Store(logger)
+}
+
+// Logger returns the logger of the connection
+func (w *Wire) Logger() *logrus.Entry {
+	return w.logger.Load().(*logrus.Entry)
+}
+
+// log returns the logger of the connection in the phase
+func (w *Wire) log(phase string) *logrus.Entry {
+	return w.Logger().WithField(logging.FieldPhase, phase)
 }
 
 // ClientReceive acts as a client to receive message from server-bak on the wire
 func (w *Wire) ClientReceive() {
+	defer close(w.Closed)
 	for {
-		_, bytes, err := w.conn.ReadMessage()
+		bytes, err := w.conn.ReadMessage()
 		if err != nil {
-			if debugMessage {
-				log.Println("client read err :", err)
-			}
+			w.log(logging.PhaseClose).Debugf("client read err: %v", err)
 			w.mutex.Lock()
 			w.closed = true
 			w.mutex.Unlock()
 			break
 		}
-		if debugMessage {
-			log.Printf("message received ->\n%v", string(bytes))
+		if logging.IsDebug() {
+			w.log(logging.PhaseStream).Debugf("message received -> %v", string(bytes))
+		}
+		var msg *Message
+		if IsEnvelopeOnWire(bytes) {
+			msg, err = ParseEnvelopeOnWire(bytes)
+		} else {
+			msg, err = ParseServerMessageOnWire(bytes)
 		}
-		msg, err := ParseResponseOnWire(bytes)
 		if err == nil {
 			w.MsgCh <- *msg
 			continue
@@ -62,20 +109,46 @@ func (w *Wire) ClientReceive() {
 
 // ServerReceive acts as a server-bak to receive message from client on the wire
 func (w *Wire) ServerReceive() {
+	defer close(w.Closed)
 	first := true
 	for {
-		_, bytes, err := w.conn.ReadMessage()
+		bytes, err := w.conn.ReadMessage()
 		if err != nil {
-			if debugMessage {
-				log.Println("server-bak read err:", err)
-			}
+			w.log(logging.PhaseClose).Debugf("server read err: %v", err)
 			w.mutex.Lock()
 			w.closed = true
 			w.mutex.Unlock()
 			break
 		}
-		if debugMessage {
-			log.Printf("message received ->\n%v", string(bytes))
+		if logging.IsDebug() {
+			w.log(logging.PhaseStream).Debugf("message received -> %v", string(bytes))
+		}
+		if IsAudioOnWire(bytes) {
+			msg, err := ParseAudioOnWire(bytes)
+			if err == nil {
+				w.MsgCh <- *msg
+				continue
+			}
+			w.ErrCh <- err
+			continue
+		}
+		if IsEnvelopeOnWire(bytes) {
+			if first {
+				// response to the client in envelopes as it requests
+				first = false
+				w.SetEnvelope(true)
+			}
+			msg, err := ParseEnvelopeOnWire(bytes)
+			if err == nil {
+				w.MsgCh <- *msg
+				continue
+			}
+			w.ErrCh <- err
+			continue
+		}
+		if !w.legacy {
+			w.ErrCh <- fmt.Errorf("wire error - un-enveloped message is not accepted\n%v", string(bytes))
+			continue
 		}
 		if first {
 			first = false
@@ -100,6 +173,20 @@ func (w *Wire) ServerReceive() {
 	}
 }
 
+// SetLegacy sets if the un-enveloped messages of legacy clients are accepted, which is true
+// by default. It should be called before ServerReceive.
+func (w *Wire) SetLegacy(on bool) {
+	w.legacy = on
+}
+
+// SetEnvelope sets if the messages are sent in envelopes.
+// Client sets it on to talk in envelopes, and server follows the client.
+func (w *Wire) SetEnvelope(on bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.enveloped = on
+}
+
 // Send sends message to the wire
 func (w *Wire) Send(msg *Message) error {
 	w.mutex.Lock()
@@ -108,24 +195,24 @@ func (w *Wire) Send(msg *Message) error {
 		return fmt.Errorf("websocket connection is closed")
 	}
 
-	wireBytes, err := msg.BytesOnWire()
-	if debugMessage {
-		log.Printf("message sent ->\n%v", string(wireBytes))
+	var wireBytes []byte
+	var err error
+	if w.enveloped {
+		wireBytes, err = msg.EnvelopeOnWire()
+	} else {
+		wireBytes, err = msg.BytesOnWire()
 	}
-	if err != nil {
-		return err
+	if logging.IsDebug() {
+		w.log(logging.PhaseResponse).Debugf("message sent -> %v", string(wireBytes))
 	}
-
-	wc, err := w.conn.NextWriter(ws.BinaryMessage)
 	if err != nil {
-		w.closed = false
-		return fmt.Errorf("wire error - fail to write message to wire, error: %v", err)
+		return err
 	}
 
-	if _, err = wc.Write(wireBytes); err != nil {
+	if err = w.conn.WriteMessage(wireBytes); err != nil {
 		return fmt.Errorf("wire error - fail to write message to wire, error: %v", err)
 	}
-	return wc.Close()
+	return nil
 }
 
 // SendCloseMessage sends close message for closing connection gracefully
@@ -136,7 +223,19 @@ func (w *Wire) SendCloseMessage(code int, msg string) {
 		return
 	}
 
-	w.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	w.conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(code, msg))
+	if code != ws.CloseNormalClosure {
+		w.log(logging.PhaseClose).Infof("closing connection, code %v, reason: %v", code, msg)
+	}
+	w.conn.WriteClose(code, msg)
 	time.Sleep(closeGracePeriod)
 }
+
+// RemoteAddr returns the remote network address of the peer
+func (w *Wire) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+// Close closes the connection under the wire
+func (w *Wire) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,186 @@
+package hly
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// memTransport is the transport whose inbound frames are sent by the test
+type memTransport struct {
+	in  chan []byte
+	out chan []byte
+}
+
+func newMemTransport() *memTransport {
+	return &memTransport{in: make(chan []byte), out: make(chan []byte, 16)}
+}
+
+func (t *memTransport) ReadMessage() ([]byte, error) {
+	msg, ok := <-t.in
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+func (t *memTransport) WriteMessage(msg []byte) error {
+	t.out <- msg
+	return nil
+}
+
+func (t *memTransport) WriteClose(code int, reason string) error { return nil }
+func (t *memTransport) Close() error                             { return nil }
+func (t *memTransport) RemoteAddr() net.Addr                     { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+const (
+	envelopedRequest = `{"version":1,"type":"request","payload":{"cid":"c1","rate":"8000","business":{"uid":"u1"}}}`
+	envelopedChunk   = `{"version":1,"type":"chunk","payload":{"cid":"c1","chunk":1,"audio":"AAA="}}`
+	envelopedEOS     = `{"version":1,"type":"eos","payload":{"cid":"c1"}}`
+	envelopedCancel  = `{"version":1,"type":"cancel","payload":{"cid":"c1","reason":"hangup"}}`
+	legacyRequest    = `{"cid":"c1","rate":"8000","business":{"uid":"u1"}}`
+	legacyChunk      = `{"cid":"c1","chunk":1,"audio":"AAA="}`
+)
+
+func TestServerReceive(t *testing.T) {
+	tests := []struct {
+		name      string
+		legacy    bool
+		frames    []string
+		types     []string
+		errs      int
+		enveloped bool
+	}{
+		{"enveloped", true, []string{envelopedRequest, envelopedChunk, envelopedEOS, envelopedCancel}, []string{RequestType, ChunkType, EndOfStreamType, CancelType}, 0, true},
+		{"enveloped without legacy", false, []string{envelopedRequest, envelopedChunk}, []string{RequestType, ChunkType}, 0, true},
+		{"legacy", true, []string{legacyRequest, legacyChunk, legacyChunk}, []string{RequestType, ChunkType, ChunkType}, 0, false},
+		{"legacy not accepted", false, []string{legacyRequest, legacyChunk}, nil, 2, false},
+		{"illegal envelope", true, []string{`{"version":2,"type":"request","payload":{}}`, `{"version":1,"type":"foo","payload":{}}`}, nil, 2, true},
+		{"binary audio", true, []string{envelopedRequest, string([]byte{audioMarker, 0, 0, 0, 1, 0, 0})}, []string{RequestType, AudioType}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newMemTransport()
+			wire := newWire(conn)
+			wire.SetLegacy(tt.legacy)
+			go wire.ServerReceive()
+
+			var types []string
+			errs := 0
+			for _, frame := range tt.frames {
+				conn.in <- []byte(frame)
+				select {
+				case msg := <-wire.MsgCh:
+					types = append(types, msg.Type)
+				case <-wire.ErrCh:
+					errs++
+				case <-time.After(time.Second):
+					t.Fatalf("no message is received for %s", frame)
+				}
+			}
+			if len(types) != len(tt.types) || errs != tt.errs {
+				t.Fatalf("received %v and %v errors, want %v and %v errors", types, errs, tt.types, tt.errs)
+			}
+			for i := range types {
+				if types[i] != tt.types[i] {
+					t.Errorf("message %v type = %v, want %v", i, types[i], tt.types[i])
+				}
+			}
+
+			// the response follows the shape of the messages from the client
+			req := &Request{CID: "c1"}
+			if err := wire.Send(req.NewCancelResponse().Message()); err != nil {
+				t.Fatal(err)
+			}
+			out := <-conn.out
+			if IsEnvelopeOnWire(out) != tt.enveloped {
+				t.Errorf("response %s enveloped = %v, want %v", out, !tt.enveloped, tt.enveloped)
+			}
+			var msg *Message
+			var err error
+			if tt.enveloped {
+				msg, err = ParseEnvelopeOnWire(out)
+			} else {
+				msg, err = ParseServerMessageOnWire(out)
+			}
+			if err != nil || msg.Type != ResponseType {
+				t.Errorf("response %s is parsed to %v, error = %v, want a response", out, msg, err)
+			}
+			close(conn.in)
+			<-wire.Closed
+		})
+	}
+}
+
+func TestParseEnvelopeOnWire(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    string
+		envelope bool
+		typ      string
+		ok       bool
+	}{
+		{"request", envelopedRequest, true, RequestType, true},
+		{"chunk", envelopedChunk, true, ChunkType, true},
+		{"eos", envelopedEOS, true, EndOfStreamType, true},
+		{"cancel", envelopedCancel, true, CancelType, true},
+		{"legacy request", legacyRequest, false, "", false},
+		{"legacy chunk", legacyChunk, false, "", false},
+		{"unsupported version", `{"version":2,"type":"chunk","payload":{}}`, true, "", false},
+		{"no version", `{"type":"chunk","payload":{}}`, true, "", false},
+		{"empty payload", `{"version":1,"type":"chunk"}`, true, "", false},
+		{"illegal type", `{"version":1,"type":"foo","payload":{}}`, true, "", false},
+		{"not json", `chunk`, false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEnvelopeOnWire([]byte(tt.frame)); got != tt.envelope {
+				t.Errorf("IsEnvelopeOnWire() = %v, want %v", got, tt.envelope)
+			}
+			msg, err := ParseEnvelopeOnWire([]byte(tt.frame))
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseEnvelopeOnWire() error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && msg.Type != tt.typ {
+				t.Errorf("ParseEnvelopeOnWire() type = %v, want %v", msg.Type, tt.typ)
+			}
+		})
+	}
+}
+
+func TestParseServerMessageOnWire(t *testing.T) {
+	req := &Request{CID: "c1"}
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{"response", req.NewCancelResponse().Message()},
+		{"event", (&Event{CID: "c1", Event: EventVoiceBegin, Offset: 420}).Message()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, enveloped := range []bool{false, true} {
+				var frame []byte
+				var err error
+				if enveloped {
+					frame, err = tt.msg.EnvelopeOnWire()
+				} else {
+					frame, err = tt.msg.BytesOnWire()
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				var msg *Message
+				if IsEnvelopeOnWire(frame) {
+					msg, err = ParseEnvelopeOnWire(frame)
+				} else {
+					msg, err = ParseServerMessageOnWire(frame)
+				}
+				if err != nil || msg.Type != tt.msg.Type {
+					t.Errorf("%s is parsed to %v, error = %v, want %v", frame, msg, err, tt.msg.Type)
+				}
+			}
+		})
+	}
+}
@@ -14,6 +14,12 @@ var addr = flag.String("addr", "127.0.0.1:6000", "http service address")
 
 //var addr = flag.String("addr", "114.116.110.22:6000", "http service address")
 
+var mode = flag.String("mode", hly.ModeSingle, "detecting mode, single or multiple")
+
+var transfer = flag.String("transfer", hly.TransferJSON, "audio transfer, json or binary")
+
+var chunk = flag.Int("chunk", 0, "bytes of audio in a chunk, 80ms audio if 0")
+
 func main() {
 	flag.Parse()
 	log.SetFlags(0)
@@ -34,6 +40,10 @@ func main() {
 	fn := "../data/haichao_test_01.wav"
 
 	log.Printf("detecting %s", fn)
-	hly.ClientRequest(u.String(), fn)
+	hly.ClientRequestWithOptions(u.String(), fn, &hly.ClientOptions{
+		Mode:      *mode,
+		Transfer:  *transfer,
+		ChunkSize: *chunk,
+	})
 
 }
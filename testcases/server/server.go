@@ -1,33 +1,110 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/henryleu/vads/hly"
+	"github.com/henryleu/vads/hly/config"
+	"github.com/henryleu/vads/hly/logging"
+	"github.com/henryleu/vads/hly/metrics"
 )
 
-var addr = flag.String("addr", "0.0.0.0:6000", "http service address")
+var addr = flag.String("addr", "", "http service address, server.listen in config if empty")
+
+var tcpAddr = flag.String("tcp", "", "raw tcp service address with length-prefixed frames, server.tcp_listen in config if empty")
+
+var configFile = flag.String("config", "", "yaml config file, the default config overridden by env vars if empty")
 
 func main() {
 	flag.Parse()
-	log.SetFlags(0)
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		logging.Phase(logging.PhaseConfig).Fatal(err)
+	}
+	config.Set(cfg)
+	cfg.Log.Apply()
+	logger := logging.Phase(logging.PhaseServer)
+
+	// reload the config for new sessions on SIGHUP
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 	go func() {
-		for {
-			select {
-			case <-interrupt:
-				log.Println("process is interrupted")
-				os.Exit(0)
-			}
+		for range hangup {
+			config.Reload(*configFile)
 		}
 	}()
+	if *addr == "" {
+		*addr = cfg.Server.Listen
+	}
+	if *tcpAddr == "" {
+		*tcpAddr = cfg.Server.TCPListen
+	}
+
+	if *tcpAddr != "" {
+		l, err := net.Listen("tcp", *tcpAddr)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Infof("server is listening on tcp %v", *tcpAddr)
+		go func() {
+			err := hly.ServeTCP(l)
+			if err != hly.ErrServerClosed {
+				logger.Fatal(err)
+			}
+		}()
+	}
+
+	// the admin endpoints are never served on the public listener
+	var adminServer *http.Server
+	if cfg.Server.AdminListen != "" {
+		adminServer = &http.Server{Addr: cfg.Server.AdminListen, Handler: hly.DefaultServer.AdminHandler(*configFile)}
+		logger.Infof("admin is listening on %v", cfg.Server.AdminListen)
+		go func() {
+			err := adminServer.ListenAndServe()
+			if err != http.ErrServerClosed {
+				logger.Fatal(err)
+			}
+		}()
+	}
 
 	http.HandleFunc("/websocket/hly/calling", hly.HandleMRCP)
-	log.Printf("server is listening on %v\n", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	http.Handle("/metrics", metrics.Handler())
+	http.HandleFunc("/healthz", hly.HealthzHandler())
+	http.HandleFunc("/readyz", hly.DefaultServer.ReadyzHandler())
+	server := &http.Server{Addr: *addr}
+
+	// drain the sessions on SIGINT or SIGTERM before exiting
+	done := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		timeout := config.Current().Server.ShutdownTimeout
+		logger.Infof("process is interrupted, shutting down in %v", timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		server.Shutdown(ctx)
+		err := hly.DefaultServer.Shutdown(ctx)
+		if adminServer != nil {
+			adminServer.Close()
+		}
+		if err != nil {
+			logger.Warnf("sessions are stopped on shutting down, error: %v", err)
+		}
+		close(done)
+	}()
+
+	logger.Infof("server is listening on %v", *addr)
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		logger.Fatal(err)
+	}
+	<-done
+	logger.Info("server is shut down")
 }